@@ -0,0 +1,97 @@
+package alertmanager
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client calls the Alertmanager API v2.
+type Client struct {
+	URL        *url.URL
+	HTTPClient *http.Client
+}
+
+func NewClient(rawURL string) (*Client, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid Alertmanager URL %q: must be http or https", rawURL)
+	}
+	return &Client{
+		URL:        u,
+		HTTPClient: http.DefaultClient,
+	}, nil
+}
+
+type Matcher struct {
+	Name    string `json:"name"`
+	Value   string `json:"value"`
+	IsRegex bool   `json:"isRegex"`
+	IsEqual bool   `json:"isEqual"`
+}
+
+type Silence struct {
+	Matchers  []Matcher `json:"matchers"`
+	StartsAt  time.Time `json:"startsAt"`
+	EndsAt    time.Time `json:"endsAt"`
+	CreatedBy string    `json:"createdBy"`
+	Comment   string    `json:"comment"`
+}
+
+// CreateSilence creates the silence and returns its ID.
+func (c *Client) CreateSilence(ctx context.Context, silence *Silence) (string, error) {
+	b, err := json.Marshal(silence)
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint("api/v2/silences"), bytes.NewReader(b))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.HTTPClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to create silence: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	var result struct {
+		SilenceID string `json:"silenceID"`
+	}
+	if err := json.Unmarshal(body, &result); err != nil {
+		return "", err
+	}
+	return result.SilenceID, nil
+}
+
+// SilenceURL returns the URL of the silence in the Alertmanager UI served at externalURL,
+// or at the API URL if externalURL is empty.
+func (c *Client) SilenceURL(externalURL string, id string) string {
+	base := externalURL
+	if base == "" {
+		base = c.URL.String()
+	}
+	return strings.TrimSuffix(base, "/") + "/#/silences/" + url.PathEscape(id)
+}
+
+func (c *Client) endpoint(path string) string {
+	return strings.TrimSuffix(c.URL.String(), "/") + "/" + path
+}
@@ -0,0 +1,197 @@
+package alertmanager
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/notifier"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	silenceCommand = "/silence"
+	silenceUsage   = "Usage: `/silence <duration> <reason>`, e.g. `/silence 2h deploying a fix`"
+)
+
+// alertDataRegexp matches the payload written by the body template. The JSON never contains "-->" since
+// json.Marshal escapes '>'.
+var alertDataRegexp = regexp.MustCompile(`<!-- alert data: (.*?) -->`)
+
+var silenceCommandCount = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "silence_commands_total",
+		Help: "Number of /silence commands handled.",
+	},
+	// result: "success" if the silence was created, or "failure" otherwise
+	[]string{"result"},
+)
+
+// trustedAssociations are the author associations allowed to create silences,
+// so that anyone who can comment on a public repository cannot.
+var trustedAssociations = map[string]bool{
+	"OWNER":        true,
+	"MEMBER":       true,
+	"COLLABORATOR": true,
+}
+
+// Silencer creates silences in Alertmanager from `/silence` comments on alert issues.
+type Silencer struct {
+	Alertmanager *Client
+	GitHubClient *github.Client
+	// If true, neither silences nor replies are created, and they are logged instead.
+	DryRun bool
+}
+
+type silenceRequest struct {
+	duration time.Duration
+	reason   string
+}
+
+// parseSilenceCommand parses the first line of the comment. It returns nil without an error
+// if the comment is not a silence command.
+func parseSilenceCommand(comment string) (*silenceRequest, error) {
+	line, _, _ := strings.Cut(strings.TrimSpace(comment), "\n")
+	fields := strings.Fields(line)
+	if len(fields) == 0 || fields[0] != silenceCommand {
+		return nil, nil
+	}
+	if len(fields) < 3 {
+		return nil, errors.New("duration and reason are required")
+	}
+
+	duration, err := time.ParseDuration(fields[1])
+	if err != nil {
+		return nil, err
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("duration must be positive: %s", fields[1])
+	}
+	return &silenceRequest{
+		duration: duration,
+		reason:   strings.Join(fields[2:], " "),
+	}, nil
+}
+
+// alertData returns the payload the issue body was last rendered from.
+func alertData(body string) (*types.WebhookPayload, error) {
+	m := alertDataRegexp.FindStringSubmatch(body)
+	if m == nil {
+		return nil, errors.New("the issue body has no alert data")
+	}
+	payload := &types.WebhookPayload{}
+	if err := json.Unmarshal([]byte(m[1]), payload); err != nil {
+		return nil, fmt.Errorf("invalid alert data: %w", err)
+	}
+	return payload, nil
+}
+
+// silenceMatchers returns equality matchers for the group labels, sorted by name.
+func silenceMatchers(payload *types.WebhookPayload) ([]Matcher, error) {
+	if len(payload.GroupLabels) == 0 {
+		return nil, errors.New("the alert group has no group labels, and a silence without matchers would silence all alerts")
+	}
+	matchers := make([]Matcher, 0, len(payload.GroupLabels))
+	for name, value := range payload.GroupLabels {
+		matchers = append(matchers, Matcher{Name: name, Value: value, IsEqual: true})
+	}
+	sort.Slice(matchers, func(i, j int) bool {
+		return matchers[i].Name < matchers[j].Name
+	})
+	return matchers, nil
+}
+
+func formatMatchers(matchers []Matcher) string {
+	ss := make([]string, 0, len(matchers))
+	for _, m := range matchers {
+		ss = append(ss, fmt.Sprintf("%s=%q", m.Name, m.Value))
+	}
+	return "{" + strings.Join(ss, ", ") + "}"
+}
+
+// HandleIssueComment creates a silence if the comment is a silence command, and replies with the result.
+func (s *Silencer) HandleIssueComment(ctx context.Context, event *github.IssueCommentEvent) error {
+	if event.GetAction() != "created" || event.GetIssue().IsPullRequest() {
+		return nil
+	}
+	comment := event.GetComment()
+	req, err := parseSilenceCommand(comment.GetBody())
+	if req == nil && err == nil {
+		return nil
+	}
+
+	owner := event.GetRepo().GetOwner().GetLogin()
+	repo := event.GetRepo().GetName()
+	number := event.GetIssue().GetNumber()
+	logger := log.With().Str("owner", owner).Str("repo", repo).Int("issue", number).Str("user", comment.GetUser().GetLogin()).Logger()
+
+	if !trustedAssociations[comment.GetAuthorAssociation()] {
+		logger.Warn().Str("authorAssociation", comment.GetAuthorAssociation()).Msg("ignoring a silence command from an untrusted user")
+		return nil
+	}
+	if err != nil {
+		silenceCommandCount.WithLabelValues("failure").Inc()
+		return s.reply(ctx, owner, repo, number, fmt.Sprintf("Failed to create a silence: %s\n\n%s", err, silenceUsage))
+	}
+
+	payload, err := alertData(event.GetIssue().GetBody())
+	if err != nil {
+		silenceCommandCount.WithLabelValues("failure").Inc()
+		return s.reply(ctx, owner, repo, number, fmt.Sprintf("Failed to create a silence: %s", err))
+	}
+	matchers, err := silenceMatchers(payload)
+	if err != nil {
+		silenceCommandCount.WithLabelValues("failure").Inc()
+		return s.reply(ctx, owner, repo, number, fmt.Sprintf("Failed to create a silence: %s", err))
+	}
+
+	now := time.Now()
+	silence := &Silence{
+		Matchers:  matchers,
+		StartsAt:  now,
+		EndsAt:    now.Add(req.duration),
+		CreatedBy: comment.GetUser().GetLogin(),
+		Comment:   fmt.Sprintf("%s (%s)", req.reason, comment.GetHTMLURL()),
+	}
+	if s.DryRun {
+		logger.Info().Str("matchers", formatMatchers(matchers)).Dur("duration", req.duration).Msg("dry run: silence not created")
+		return nil
+	}
+
+	id, err := s.Alertmanager.CreateSilence(ctx, silence)
+	if err != nil {
+		silenceCommandCount.WithLabelValues("failure").Inc()
+		logger.Error().Err(err).Msg("failed to create silence")
+		if replyErr := s.reply(ctx, owner, repo, number, "Failed to create a silence. See the logs of alertmanager-to-github for details."); replyErr != nil {
+			logger.Error().Err(replyErr).Msg("failed to reply to silence command")
+		}
+		return err
+	}
+	silenceCommandCount.WithLabelValues("success").Inc()
+	logger.Info().Str("silenceID", id).Str("matchers", formatMatchers(matchers)).Msg("created silence")
+
+	return s.reply(ctx, owner, repo, number, fmt.Sprintf("Silenced alerts matching `%s` until %s: %s",
+		formatMatchers(matchers), silence.EndsAt.UTC().Format(time.RFC3339), s.Alertmanager.SilenceURL(payload.ExternalURL, id)))
+}
+
+func (s *Silencer) reply(ctx context.Context, owner string, repo string, number int, body string) error {
+	if s.DryRun {
+		log.Info().Str("owner", owner).Str("repo", repo).Int("issue", number).Str("body", body).Msg("dry run: reply not posted")
+		return nil
+	}
+	_, response, err := s.GitHubClient.Issues.CreateComment(ctx, owner, repo, number, &github.IssueComment{Body: github.String(body)})
+	if err != nil {
+		return err
+	}
+	notifier.UpdateGithubApiMetrics("issues", response)
+	return nil
+}
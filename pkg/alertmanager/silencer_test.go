@@ -0,0 +1,109 @@
+package alertmanager
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseSilenceCommand(t *testing.T) {
+	tests := []struct {
+		comment  string
+		expected *silenceRequest
+		err      bool
+	}{
+		{comment: "looking into it", expected: nil},
+		{comment: "/silenced", expected: nil},
+		{comment: "/silence 2h deploying a fix\nmore details", expected: &silenceRequest{duration: 2 * time.Hour, reason: "deploying a fix"}},
+		{comment: "  /silence 30m   noisy  \n", expected: &silenceRequest{duration: 30 * time.Minute, reason: "noisy"}},
+		{comment: "/silence 2h", err: true},
+		{comment: "/silence two-hours reason", err: true},
+		{comment: "/silence -1h reason", err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.comment, func(t *testing.T) {
+			req, err := parseSilenceCommand(tt.comment)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected, req)
+		})
+	}
+}
+
+func TestHandleIssueComment(t *testing.T) {
+	var silence Silence
+	am := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v2/silences", r.URL.Path)
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&silence))
+		_, _ = w.Write([]byte(`{"silenceID": "abc"}`))
+	}))
+	defer am.Close()
+
+	var replies []string
+	gh := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repos/owner/repo/issues/1/comments", r.URL.Path)
+		comment := &github.IssueComment{}
+		require.NoError(t, json.NewDecoder(r.Body).Decode(comment))
+		replies = append(replies, comment.GetBody())
+		_, _ = w.Write([]byte(`{}`))
+	}))
+	defer gh.Close()
+
+	client, err := NewClient(am.URL)
+	require.NoError(t, err)
+	githubClient := github.NewClient(nil)
+	githubClient.BaseURL, err = url.Parse(gh.URL + "/")
+	require.NoError(t, err)
+	s := &Silencer{Alertmanager: client, GitHubClient: githubClient}
+
+	event := func(body string, association string) *github.IssueCommentEvent {
+		return &github.IssueCommentEvent{
+			Action: github.String("created"),
+			Repo: &github.Repository{
+				Name:  github.String("repo"),
+				Owner: &github.User{Login: github.String("owner")},
+			},
+			Issue: &github.Issue{
+				Number: github.Int(1),
+				Body:   github.String(`(body)<!-- alert data: {"groupLabels":{"alertname":"HighLatency","job":"api"},"externalURL":"https://am.example.com"} -->`),
+			},
+			Comment: &github.IssueComment{
+				Body:              github.String(body),
+				AuthorAssociation: github.String(association),
+				User:              &github.User{Login: github.String("alice")},
+				HTMLURL:           github.String("https://github.com/owner/repo/issues/1#issuecomment-1"),
+			},
+		}
+	}
+
+	ctx := context.Background()
+	require.NoError(t, s.HandleIssueComment(ctx, event("not a command", "MEMBER")))
+	require.NoError(t, s.HandleIssueComment(ctx, event("/silence 2h deploying a fix", "NONE")))
+	assert.Empty(t, replies)
+
+	require.NoError(t, s.HandleIssueComment(ctx, event("/silence 2h deploying a fix", "MEMBER")))
+	assert.Equal(t, []Matcher{
+		{Name: "alertname", Value: "HighLatency", IsEqual: true},
+		{Name: "job", Value: "api", IsEqual: true},
+	}, silence.Matchers)
+	assert.Equal(t, "alice", silence.CreatedBy)
+	assert.Equal(t, "deploying a fix (https://github.com/owner/repo/issues/1#issuecomment-1)", silence.Comment)
+	assert.Equal(t, 2*time.Hour, silence.EndsAt.Sub(silence.StartsAt))
+	require.Len(t, replies, 1)
+	assert.Contains(t, replies[0], "https://am.example.com/#/silences/abc")
+
+	require.NoError(t, s.HandleIssueComment(ctx, event("/silence soon", "OWNER")))
+	require.Len(t, replies, 2)
+	assert.Contains(t, replies[1], "Failed to create a silence")
+}
@@ -1,6 +1,7 @@
 package cli
 
 import (
+	"bytes"
 	"context"
 	"embed"
 	"encoding/json"
@@ -8,22 +9,36 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"os"
+	"os/signal"
+	"path/filepath"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/bradleyfalzon/ghinstallation/v2"
 	"github.com/google/go-github/v54/github"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/alertmanager"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/config"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/index"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/matcher"
 	"github.com/pfnet-research/alertmanager-to-github/pkg/notifier"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/queue"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/scheduler"
 	"github.com/pfnet-research/alertmanager-to-github/pkg/server"
 	"github.com/pfnet-research/alertmanager-to-github/pkg/template"
 	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/watchdog"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/rs/zerolog/log"
 	"github.com/urfave/cli/v2"
 	"golang.org/x/oauth2"
 )
 
 const flagListen = "listen"
+const flagConfigFile = "config-file"
 const flagGitHubURL = "github-url"
 const flagLabels = "labels"
 const flagBodyTemplateFile = "body-template-file"
@@ -33,11 +48,64 @@ const flagGitHubAppInstallationID = "github-app-installation-id"
 const flagGitHubAppPrivateKey = "github-app-private-key"
 const flagGitHubToken = "github-token"
 const flagAlertIDTemplate = "alert-id-template"
+const flagLabelsTemplate = "labels-template"
+const flagAssigneesTemplate = "assignees-template"
+const flagMilestoneTemplate = "milestone-template"
+const flagMetadataCacheTTL = "metadata-cache-ttl"
 const flagTemplateFile = "template-file"
 const flagPayloadFile = "payload-file"
 const flagAutoCloseResolvedIssues = "auto-close-resolved-issues"
+const flagAutoCloseDelay = "auto-close-delay"
+const flagMinFiringDuration = "min-firing-duration"
+const flagIncludeAlerts = "include-alerts"
+const flagExcludeAlerts = "exclude-alerts"
+const flagWatchdogMatchers = "watchdog-matchers"
+const flagWatchdogTimeout = "watchdog-timeout"
+const flagWatchdogRepository = "watchdog-repository"
+const flagAlertmanagerURL = "alertmanager-url"
+const flagGitHubWebhookSecretFile = "github-webhook-secret-file"
 const flagReopenWindow = "reopen-window"
+const flagManualClosePolicy = "manual-close-policy"
+const flagManualCloseDuration = "manual-close-duration"
 const flagNoPreviousIssue = "no-previous-issue"
+const flagDataDir = "data-dir"
+const flagQueueWorkers = "queue-workers"
+const flagQueueInitialBackoff = "queue-initial-backoff"
+const flagQueueMaxBackoff = "queue-max-backoff"
+const flagQueueMaxAttempts = "queue-max-attempts"
+const flagIssueIndex = "issue-index"
+const flagIssueIndexBootstrapRepos = "issue-index-bootstrap-repos"
+const flagWebhookBearerTokenFile = "webhook-bearer-token-file"
+const flagWebhookBasicAuthFile = "webhook-basic-auth-file"
+const flagAllowedRepositories = "allowed-repositories"
+const flagDeniedRepositories = "denied-repositories"
+const flagFallbackRepository = "fallback-repository"
+const flagReadinessCacheTTL = "readiness-cache-ttl"
+const flagShutdownGracePeriod = "shutdown-grace-period"
+const flagNotifyTimeout = "notify-timeout"
+const flagDryRun = "dry-run"
+const flagDryRunPlanHistory = "dry-run-plan-history"
+const flagIssuePerAlert = "issue-per-alert"
+const flagCommentOnChanges = "comment-on-changes"
+const flagCommentTemplateFile = "comment-template-file"
+const flagFlappingThreshold = "flapping-threshold"
+const flagFlappingWindow = "flapping-window"
+const flagFlappingStablePeriod = "flapping-stable-period"
+const flagFlappingLabel = "flapping-label"
+const flagAcknowledgeLabel = "acknowledge-label"
+const flagAcknowledgeByAssignee = "acknowledge-by-assignee"
+
+// How often flapping alerts are checked for whether they have become stable.
+const flappingCheckInterval = time.Minute
+
+// How often the watchdog checks whether heartbeats have arrived in time.
+const watchdogCheckInterval = 30 * time.Second
+
+const (
+	issueIndexNone   = "none"
+	issueIndexMemory = "memory"
+	issueIndexFile   = "file"
+)
 
 const defaultPayload = `{
   "version": "4",
@@ -128,6 +196,11 @@ func App() *cli.App {
 						Usage:   "HTTP listen on",
 						EnvVars: []string{"ATG_LISTEN"},
 					},
+					&cli.StringFlag{
+						Name:    flagConfigFile,
+						Usage:   "Config file defining routes. The other flags are used as the default route",
+						EnvVars: []string{"ATG_CONFIG_FILE"},
+					},
 					&cli.StringFlag{
 						Name:    flagGitHubURL,
 						Usage:   "GitHub Enterprise URL (e.g. https://github.example.com)",
@@ -154,6 +227,27 @@ func App() *cli.App {
 						Usage:   "Alert ID template",
 						EnvVars: []string{"ATG_ALERT_ID_TEMPLATE"},
 					},
+					&cli.StringFlag{
+						Name:    flagLabelsTemplate,
+						Usage:   "Template of additional issue labels separated by commas or newlines. Labels which do not exist in the repository are skipped",
+						EnvVars: []string{"ATG_LABELS_TEMPLATE"},
+					},
+					&cli.StringFlag{
+						Name:    flagAssigneesTemplate,
+						Usage:   "Template of issue assignees separated by commas or newlines. Users who cannot be assigned in the repository are skipped",
+						EnvVars: []string{"ATG_ASSIGNEES_TEMPLATE"},
+					},
+					&cli.StringFlag{
+						Name:    flagMilestoneTemplate,
+						Usage:   "Template of the issue milestone title. Milestones which are not open in the repository are skipped",
+						EnvVars: []string{"ATG_MILESTONE_TEMPLATE"},
+					},
+					&cli.DurationFlag{
+						Name:    flagMetadataCacheTTL,
+						Value:   5 * time.Minute,
+						Usage:   "How long the labels, milestones and assignees of repositories are cached to validate the templated values",
+						EnvVars: []string{"ATG_METADATA_CACHE_TTL"},
+					},
 					&cli.Int64Flag{
 						Name:     flagGitHubAppID,
 						Required: false,
@@ -185,6 +279,20 @@ func App() *cli.App {
 						Usage:    "Should issues be automatically closed when resolved. If alerts have 'atg_skip_auto_close=true' annotation, issues will not be auto-closed.",
 						EnvVars:  []string{"ATG_AUTO_CLOSE_RESOLVED_ISSUES"},
 					},
+					&noDefaultDurationFlag{
+						cli.DurationFlag{
+							Name:    flagAutoCloseDelay,
+							Usage:   "Close resolved issues only if the alerts do not fire again within the delay. Alerts can override it with 'atg_auto_close_delay' annotation. Pending closes are persisted if --data-dir is specified",
+							EnvVars: []string{"ATG_AUTO_CLOSE_DELAY"},
+						},
+					},
+					&noDefaultDurationFlag{
+						cli.DurationFlag{
+							Name:    flagMinFiringDuration,
+							Usage:   "Create issues only for alerts which have been firing for at least this duration. Alerts can override it with 'atg_min_firing_duration' annotation. Pending creations are persisted if --data-dir is specified",
+							EnvVars: []string{"ATG_MIN_FIRING_DURATION"},
+						},
+					},
 					&noDefaultDurationFlag{
 						cli.DurationFlag{
 							Name:     flagReopenWindow,
@@ -193,6 +301,196 @@ func App() *cli.App {
 							EnvVars:  []string{"ATG_REOPEN_WINDOW"},
 						},
 					},
+					&cli.StringFlag{
+						Name:    flagManualClosePolicy,
+						Value:   string(notifier.ManualCloseReopen),
+						Usage:   "What happens to issues closed by humans while their alerts are firing (reopen, respect-until-resolved or respect-for-duration). \"reopen\" reopens them with a comment",
+						EnvVars: []string{"ATG_MANUAL_CLOSE_POLICY"},
+					},
+					&cli.DurationFlag{
+						Name:    flagManualCloseDuration,
+						Value:   24 * time.Hour,
+						Usage:   "How long issues closed by humans are kept closed with --manual-close-policy=respect-for-duration",
+						EnvVars: []string{"ATG_MANUAL_CLOSE_DURATION"},
+					},
+					&cli.BoolFlag{
+						Name:    flagIssuePerAlert,
+						Usage:   "Create an issue for each alert rather than for each alert group. Alerts are identified by their labels instead of the alert ID template",
+						EnvVars: []string{"ATG_ISSUE_PER_ALERT"},
+					},
+					&cli.BoolFlag{
+						Name:    flagCommentOnChanges,
+						Usage:   "Render the body only when an issue is created, and post a comment when alerts are added or resolved instead of rewriting the body",
+						EnvVars: []string{"ATG_COMMENT_ON_CHANGES"},
+					},
+					&cli.StringFlag{
+						Name:    flagCommentTemplateFile,
+						Usage:   "Comment template file",
+						EnvVars: []string{"ATG_COMMENT_TEMPLATE_FILE"},
+					},
+					&cli.IntFlag{
+						Name:    flagFlappingThreshold,
+						Usage:   "Number of transitions between firing and resolved within the flapping window for an alert to be considered flapping. The issues of flapping alerts are kept open and labeled. 0 disables flapping detection",
+						EnvVars: []string{"ATG_FLAPPING_THRESHOLD"},
+					},
+					&cli.DurationFlag{
+						Name:    flagFlappingWindow,
+						Value:   time.Hour,
+						Usage:   "Window in which transitions are counted for flapping detection",
+						EnvVars: []string{"ATG_FLAPPING_WINDOW"},
+					},
+					&cli.DurationFlag{
+						Name:    flagFlappingStablePeriod,
+						Value:   30 * time.Minute,
+						Usage:   "Flapping alerts are notified normally again once they have not transitioned for this duration",
+						EnvVars: []string{"ATG_FLAPPING_STABLE_PERIOD"},
+					},
+					&cli.StringFlag{
+						Name:    flagFlappingLabel,
+						Value:   "flapping",
+						Usage:   "Label added to the issues of flapping alerts",
+						EnvVars: []string{"ATG_FLAPPING_LABEL"},
+					},
+					&cli.StringFlag{
+						Name:    flagAcknowledgeLabel,
+						Usage:   "Label with which humans acknowledge issues. Acknowledged issues are not rewritten or reopened, and changes of the alerts are posted as comments until the alert group resolves",
+						EnvVars: []string{"ATG_ACKNOWLEDGE_LABEL"},
+					},
+					&cli.BoolFlag{
+						Name:    flagAcknowledgeByAssignee,
+						Usage:   "Consider issues with assignees acknowledged. Unless the issue also has the acknowledge label, the assignees are removed when the alert group resolves",
+						EnvVars: []string{"ATG_ACKNOWLEDGE_BY_ASSIGNEE"},
+					},
+					&cli.StringSliceFlag{
+						Name:    flagIncludeAlerts,
+						Usage:   "Alertmanager-style label matcher such as 'severity=~\"critical|warning\"'. If specified, only the alerts matching all of them are notified",
+						EnvVars: []string{"ATG_INCLUDE_ALERTS"},
+					},
+					&cli.StringSliceFlag{
+						Name:    flagExcludeAlerts,
+						Usage:   "Alertmanager-style label matcher such as 'alertname=\"Watchdog\"'. The alerts matching any of them are not notified",
+						EnvVars: []string{"ATG_EXCLUDE_ALERTS"},
+					},
+					&cli.StringSliceFlag{
+						Name:    flagWatchdogMatchers,
+						Usage:   "Alertmanager-style label matchers identifying heartbeat alerts such as 'alertname=\"Watchdog\"'. If specified, an issue is opened when no heartbeat arrives within the watchdog timeout. Heartbeats themselves are not notified",
+						EnvVars: []string{"ATG_WATCHDOG_MATCHERS"},
+					},
+					&cli.DurationFlag{
+						Name:    flagWatchdogTimeout,
+						Value:   10 * time.Minute,
+						Usage:   "How long to wait for a heartbeat before opening the watchdog issue",
+						EnvVars: []string{"ATG_WATCHDOG_TIMEOUT"},
+					},
+					&cli.StringFlag{
+						Name:    flagWatchdogRepository,
+						Usage:   "Repository to open the watchdog issue in as owner/repo. Defaults to the repository given by the routes",
+						EnvVars: []string{"ATG_WATCHDOG_REPOSITORY"},
+					},
+					&cli.StringFlag{
+						Name:    flagAlertmanagerURL,
+						Usage:   "Alertmanager URL (e.g. http://alertmanager:9093). If specified, '/silence <duration> <reason>' comments on issues delivered to /v1/github-webhook create silences. Requires --github-webhook-secret-file",
+						EnvVars: []string{"ATG_ALERTMANAGER_URL"},
+					},
+					&cli.StringFlag{
+						Name:    flagGitHubWebhookSecretFile,
+						Usage:   "File containing the secret GitHub webhooks delivered to /v1/github-webhook are signed with",
+						EnvVars: []string{"ATG_GITHUB_WEBHOOK_SECRET_FILE"},
+					},
+					&cli.StringFlag{
+						Name:    flagDataDir,
+						Usage:   "Directory to persist state in. If specified, webhook payloads are queued there and notified asynchronously",
+						EnvVars: []string{"ATG_DATA_DIR"},
+					},
+					&cli.IntFlag{
+						Name:    flagQueueWorkers,
+						Value:   4,
+						Usage:   "Number of workers processing the webhook queue",
+						EnvVars: []string{"ATG_QUEUE_WORKERS"},
+					},
+					&cli.DurationFlag{
+						Name:    flagQueueInitialBackoff,
+						Value:   time.Second,
+						Usage:   "Initial delay before retrying a failed webhook payload",
+						EnvVars: []string{"ATG_QUEUE_INITIAL_BACKOFF"},
+					},
+					&cli.DurationFlag{
+						Name:    flagQueueMaxBackoff,
+						Value:   5 * time.Minute,
+						Usage:   "Maximum delay before retrying a failed webhook payload",
+						EnvVars: []string{"ATG_QUEUE_MAX_BACKOFF"},
+					},
+					&cli.IntFlag{
+						Name:    flagQueueMaxAttempts,
+						Value:   20,
+						Usage:   "Number of attempts after which a failing webhook payload is written to the dead letter file and dropped. 0 means no limit",
+						EnvVars: []string{"ATG_QUEUE_MAX_ATTEMPTS"},
+					},
+					&cli.StringFlag{
+						Name:    flagIssueIndex,
+						Value:   issueIndexNone,
+						Usage:   "Where to keep the alert ID to issue index used instead of the Search API (none, memory or file). \"file\" requires --data-dir",
+						EnvVars: []string{"ATG_ISSUE_INDEX"},
+					},
+					&cli.StringSliceFlag{
+						Name:    flagIssueIndexBootstrapRepos,
+						Usage:   "Repositories (owner/repo) whose existing issues are added to the issue index on startup",
+						EnvVars: []string{"ATG_ISSUE_INDEX_BOOTSTRAP_REPOS"},
+					},
+					&cli.StringFlag{
+						Name:    flagWebhookBearerTokenFile,
+						Usage:   "File containing bearer tokens (one per line) accepted on the webhook endpoint",
+						EnvVars: []string{"ATG_WEBHOOK_BEARER_TOKEN_FILE"},
+					},
+					&cli.StringFlag{
+						Name:    flagWebhookBasicAuthFile,
+						Usage:   "File containing basic auth credentials (username:password, one per line) accepted on the webhook endpoint",
+						EnvVars: []string{"ATG_WEBHOOK_BASIC_AUTH_FILE"},
+					},
+					&cli.StringSliceFlag{
+						Name:    flagAllowedRepositories,
+						Usage:   "Owner or owner/repo glob patterns of repositories in which issues may be created. All repositories are allowed if not specified",
+						EnvVars: []string{"ATG_ALLOWED_REPOSITORIES"},
+					},
+					&cli.StringSliceFlag{
+						Name:    flagDeniedRepositories,
+						Usage:   "Owner or owner/repo glob patterns of repositories in which issues must not be created",
+						EnvVars: []string{"ATG_DENIED_REPOSITORIES"},
+					},
+					&cli.StringFlag{
+						Name:    flagFallbackRepository,
+						Usage:   "Repository (owner/repo) in which issues are created when the target repository is rejected",
+						EnvVars: []string{"ATG_FALLBACK_REPOSITORY"},
+					},
+					&cli.DurationFlag{
+						Name:    flagReadinessCacheTTL,
+						Value:   30 * time.Second,
+						Usage:   "How long the result of the GitHub API check by /readyz is cached",
+						EnvVars: []string{"ATG_READINESS_CACHE_TTL"},
+					},
+					&cli.DurationFlag{
+						Name:    flagShutdownGracePeriod,
+						Value:   30 * time.Second,
+						Usage:   "How long to wait for in-flight notifications on SIGTERM or SIGINT",
+						EnvVars: []string{"ATG_SHUTDOWN_GRACE_PERIOD"},
+					},
+					&cli.DurationFlag{
+						Name:    flagNotifyTimeout,
+						Value:   time.Minute,
+						Usage:   "Timeout of each notification including all of its GitHub API calls. 0 means no timeout",
+						EnvVars: []string{"ATG_NOTIFY_TIMEOUT"},
+					},
+					&cli.BoolFlag{
+						Name:    flagDryRun,
+						Usage:   "Do not write to GitHub, and record the planned writes instead. The plans are logged and served on /admin/plans",
+						EnvVars: []string{"ATG_DRY_RUN"},
+					},
+					&cli.IntFlag{
+						Name:    flagDryRunPlanHistory,
+						Value:   100,
+						Usage:   "Number of plans served on /admin/plans in dry-run mode",
+						EnvVars: []string{"ATG_DRY_RUN_PLAN_HISTORY"},
+					},
 				},
 			},
 			{
@@ -286,6 +584,9 @@ func templateFromString(s string) (*template.Template, error) {
 }
 
 func actionStart(c *cli.Context) error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, syscall.SIGINT)
+	defer stop()
+
 	githubClient, err := func() (*github.Client, error) {
 		appID := c.Int64(flagGitHubAppID)
 		installationID := c.Int64(flagGitHubAppInstallationID)
@@ -304,10 +605,267 @@ func actionStart(c *cli.Context) error {
 		return err
 	}
 
-	bodyReader, err := openReader(c.String(flagBodyTemplateFile), "templates/body.tmpl")
+	route, files, err := loadRoute(c)
+	if err != nil {
+		return err
+	}
+	configReloadSuccess(true)
+
+	nt, err := notifier.NewGitHub()
+	if err != nil {
+		return err
+	}
+	nt.GitHubClient = githubClient
+	nt.ReadinessCacheTTL = c.Duration(flagReadinessCacheTTL)
+	nt.MetadataCacheTTL = c.Duration(flagMetadataCacheTTL)
+	notifyTimeout := c.Duration(flagNotifyTimeout)
+	nt.NotifyTimeout = notifyTimeout
+	nt.SetRoute(route)
+	if c.Bool(flagDryRun) {
+		log.Warn().Msg("running in dry-run mode, GitHub issues will not be changed")
+		nt.DryRun = true
+		nt.Plans = notifier.NewPlanRecorder(c.Int(flagDryRunPlanHistory))
+	}
+
+	policy := &notifier.RepositoryPolicy{
+		Allow: c.StringSlice(flagAllowedRepositories),
+		Deny:  c.StringSlice(flagDeniedRepositories),
+	}
+	if fallback := c.String(flagFallbackRepository); fallback != "" {
+		owner, repo, ok := strings.Cut(fallback, "/")
+		if !ok {
+			return fmt.Errorf("invalid repository %q: must be owner/repo", fallback)
+		}
+		policy.FallbackOwner = owner
+		policy.FallbackRepo = repo
+	}
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+	nt.RepositoryPolicy = policy
+
+	if threshold := c.Int(flagFlappingThreshold); threshold > 0 {
+		if c.String(flagFlappingLabel) == "" {
+			return fmt.Errorf("--%s must not be empty", flagFlappingLabel)
+		}
+		nt.Flapping = &notifier.FlappingDetector{
+			Window:       c.Duration(flagFlappingWindow),
+			Threshold:    threshold,
+			StablePeriod: c.Duration(flagFlappingStablePeriod),
+			Label:        c.String(flagFlappingLabel),
+		}
+	}
+
+	if label, byAssignee := c.String(flagAcknowledgeLabel), c.Bool(flagAcknowledgeByAssignee); label != "" || byAssignee {
+		nt.Acknowledgment = &notifier.Acknowledgment{
+			Label:     label,
+			Assignees: byAssignee,
+		}
+	}
+
+	issueIndex, err := openIssueIndex(c.String(flagIssueIndex), c.String(flagDataDir))
+	if err != nil {
+		return err
+	}
+	var bootstrapRepos []string
+	if issueIndex != nil {
+		defer func() {
+			if err := issueIndex.Close(); err != nil {
+				log.Error().Err(err).Msg("failed to close issue index")
+			}
+		}()
+		nt.Index = issueIndex
+
+		for _, fullName := range c.StringSlice(flagIssueIndexBootstrapRepos) {
+			if _, _, ok := strings.Cut(fullName, "/"); !ok {
+				return fmt.Errorf("invalid repository %q: must be owner/repo", fullName)
+			}
+			bootstrapRepos = append(bootstrapRepos, fullName)
+		}
+	}
+
+	sched := scheduler.NewMemory()
+	if dataDir := c.String(flagDataDir); dataDir != "" {
+		sched, err = scheduler.Open(filepath.Join(dataDir, "scheduler"))
+		if err != nil {
+			return err
+		}
+	}
+	prometheus.MustRegister(sched)
+	nt.Scheduler = sched
+
+	// The notifier is fully configured above, since the goroutines below use it concurrently.
+	rl := &reloader{
+		load:  func() (*notifier.Route, []string, error) { return loadRoute(c) },
+		apply: nt.SetRoute,
+		files: files,
+	}
+	go func() {
+		if err := rl.run(ctx); err != nil {
+			log.Error().Err(err).Msg("failed to watch configuration files")
+		}
+	}()
+
+	for _, fullName := range bootstrapRepos {
+		owner, repo, _ := strings.Cut(fullName, "/")
+		go func() {
+			if err := nt.BootstrapIndex(ctx, owner, repo); err != nil {
+				log.Error().Err(err).Str("repository", fullName).Msg("failed to bootstrap issue index")
+			}
+		}()
+	}
+
+	// The loops notifying in the background finish their notifications in progress on shutdown.
+	var background sync.WaitGroup
+	runInBackground := func(run func()) {
+		background.Add(1)
+		go func() {
+			defer background.Done()
+			run()
+		}()
+	}
+	if nt.Flapping != nil {
+		runInBackground(func() { nt.RunFlappingDetector(ctx, flappingCheckInterval) })
+	}
+	if !c.Bool(flagDryRun) {
+		runInBackground(func() { sched.Run(ctx, nt.RunTask) })
+	}
+
+	srv := server.New(nt)
+	srv.Readiness = nt
+	srv.Plans = nt.Plans
+	filter, err := alertFilter(c.StringSlice(flagIncludeAlerts), c.StringSlice(flagExcludeAlerts))
+	if err != nil {
+		return err
+	}
+	srv.Filter = filter
+
+	if matchers := c.StringSlice(flagWatchdogMatchers); len(matchers) > 0 {
+		wd, err := newWatchdog(c, nt)
+		if err != nil {
+			return err
+		}
+		srv.Watchdog = wd
+		if err := wd.Start(); err != nil {
+			return err
+		}
+		runInBackground(func() { wd.Run(ctx, watchdogCheckInterval) })
+	}
+
+	credentials, err := readWebhookCredentials(c.String(flagWebhookBearerTokenFile), c.String(flagWebhookBasicAuthFile))
 	if err != nil {
 		return err
 	}
+	srv.Credentials = credentials
+
+	if amURL := c.String(flagAlertmanagerURL); amURL != "" {
+		silencer, secret, err := newSilencer(c, amURL, githubClient)
+		if err != nil {
+			return err
+		}
+		srv.IssueComments = silencer
+		srv.GitHubWebhookSecret = secret
+	}
+
+	srv.NotifyTimeout = notifyTimeout
+
+	queueDone := make(chan struct{})
+	if dataDir := c.String(flagDataDir); dataDir != "" {
+		q, err := queue.Open(filepath.Join(dataDir, "queue"))
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := q.Close(); err != nil {
+				log.Error().Err(err).Msg("failed to close queue")
+			}
+		}()
+		q.InitialBackoff = c.Duration(flagQueueInitialBackoff)
+		q.MaxBackoff = c.Duration(flagQueueMaxBackoff)
+		q.MaxAttempts = c.Int(flagQueueMaxAttempts)
+		q.IsPermanent = notifier.IsPermanent
+		prometheus.MustRegister(q)
+
+		go func() {
+			defer close(queueDone)
+			q.Run(ctx, c.Int(flagQueueWorkers), func(ctx context.Context, item *queue.Item) error {
+				ctx, cancel := notifyContext(ctx, notifyTimeout)
+				defer cancel()
+				return nt.Notify(ctx, item.Payload, item.Params)
+			})
+		}()
+		srv.Queue = q
+	} else {
+		close(queueDone)
+	}
+
+	httpServer := &http.Server{
+		Addr:    c.String(flagListen),
+		Handler: srv.Router(),
+	}
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- httpServer.ListenAndServe()
+	}()
+
+	select {
+	case err := <-serverErr:
+		return err
+	case <-ctx.Done():
+	}
+
+	log.Info().Msg("shutting down")
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), c.Duration(flagShutdownGracePeriod))
+	defer cancel()
+	// Shutdown waits for the in-flight webhook requests, including their notifications.
+	if err := httpServer.Shutdown(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("failed to shut down HTTP server gracefully")
+	}
+	select {
+	case <-queueDone:
+	case <-shutdownCtx.Done():
+		log.Warn().Msg("queued payloads being processed were not finished in the grace period, they will be retried on the next start")
+	}
+	backgroundDone := make(chan struct{})
+	go func() {
+		background.Wait()
+		close(backgroundDone)
+	}()
+	select {
+	case <-backgroundDone:
+	case <-shutdownCtx.Done():
+		log.Warn().Msg("background notifications were not finished in the grace period")
+	}
+
+	return nil
+}
+
+// notifyContext bounds a notification with the timeout. A zero timeout means no timeout.
+func notifyContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// loadRoute builds the default route from the flags, and the route tree on top of it from the config file.
+// It also returns the files it has read.
+func loadRoute(c *cli.Context) (*notifier.Route, []string, error) {
+	var files []string
+	if path := c.String(flagBodyTemplateFile); path != "" {
+		files = append(files, path)
+	}
+	if path := c.String(flagTitleTemplateFile); path != "" {
+		files = append(files, path)
+	}
+	if path := c.String(flagCommentTemplateFile); path != "" {
+		files = append(files, path)
+	}
+
+	bodyReader, err := openReader(c.String(flagBodyTemplateFile), "templates/body.tmpl")
+	if err != nil {
+		return nil, nil, err
+	}
 	defer func() {
 		if err := bodyReader.Close(); err != nil {
 			log.Error().Err(err).Msg("failed to close bodyReader")
@@ -315,12 +873,12 @@ func actionStart(c *cli.Context) error {
 	}()
 	bodyTemplate, err := templateFromReader(bodyReader)
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
 	titleReader, err := openReader(c.String(flagTitleTemplateFile), "templates/title.tmpl")
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 	defer func() {
 		if err := titleReader.Close(); err != nil {
@@ -329,12 +887,39 @@ func actionStart(c *cli.Context) error {
 	}()
 	titleTemplate, err := templateFromReader(titleReader)
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	commentReader, err := openReader(c.String(flagCommentTemplateFile), "templates/comment.tmpl")
+	if err != nil {
+		return nil, nil, err
+	}
+	defer func() {
+		if err := commentReader.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to close commentReader")
+		}
+	}()
+	commentTemplate, err := templateFromReader(commentReader)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	alertIDTemplate, err := templateFromString(c.String(flagAlertIDTemplate))
 	if err != nil {
-		return err
+		return nil, nil, err
+	}
+
+	labelsTemplate, err := optionalTemplate(c, flagLabelsTemplate)
+	if err != nil {
+		return nil, nil, err
+	}
+	assigneesTemplate, err := optionalTemplate(c, flagAssigneesTemplate)
+	if err != nil {
+		return nil, nil, err
+	}
+	milestoneTemplate, err := optionalTemplate(c, flagMilestoneTemplate)
+	if err != nil {
+		return nil, nil, err
 	}
 
 	var reopenWindow *time.Duration
@@ -343,27 +928,182 @@ func actionStart(c *cli.Context) error {
 		reopenWindow = &d
 	}
 
-	nt, err := notifier.NewGitHub()
+	manualClosePolicy, err := notifier.ParseManualClosePolicy(c.String(flagManualClosePolicy))
 	if err != nil {
-		return err
+		return nil, nil, fmt.Errorf("--%s: %w", flagManualClosePolicy, err)
 	}
-	nt.GitHubClient = githubClient
-	nt.Labels = c.StringSlice(flagLabels)
-	if nt.Labels == nil {
-		nt.Labels = []string{}
-	}
-	nt.BodyTemplate = bodyTemplate
-	nt.TitleTemplate = titleTemplate
-	nt.AlertIDTemplate = alertIDTemplate
-	nt.AutoCloseResolvedIssues = c.Bool(flagAutoCloseResolvedIssues)
-	nt.ReopenWindow = reopenWindow
-
-	router := server.New(nt).Router()
-	if err := router.Run(c.String(flagListen)); err != nil {
-		return err
+	manualCloseDuration := c.Duration(flagManualCloseDuration)
+
+	autoCloseDelay := c.Duration(flagAutoCloseDelay)
+	minFiringDuration := c.Duration(flagMinFiringDuration)
+
+	labels := c.StringSlice(flagLabels)
+	if labels == nil {
+		labels = []string{}
+	}
+	route := &notifier.Route{
+		Labels:                  labels,
+		BodyTemplate:            bodyTemplate,
+		TitleTemplate:           titleTemplate,
+		AlertIDTemplate:         alertIDTemplate,
+		LabelsTemplate:          labelsTemplate,
+		AssigneesTemplate:       assigneesTemplate,
+		MilestoneTemplate:       milestoneTemplate,
+		AutoCloseResolvedIssues: github.Bool(c.Bool(flagAutoCloseResolvedIssues)),
+		AutoCloseDelay:          &autoCloseDelay,
+		MinFiringDuration:       &minFiringDuration,
+		ReopenWindow:            reopenWindow,
+		ManualClosePolicy:       manualClosePolicy,
+		ManualCloseDuration:     &manualCloseDuration,
+		IssuePerAlert:           github.Bool(c.Bool(flagIssuePerAlert)),
+		CommentOnChanges:        github.Bool(c.Bool(flagCommentOnChanges)),
+		CommentTemplate:         commentTemplate,
 	}
 
-	return nil
+	if path := c.String(flagConfigFile); path != "" {
+		cfg, err := config.Load(path)
+		if err != nil {
+			return nil, nil, err
+		}
+		files = append(files, path)
+		files = append(files, cfg.TemplateFiles()...)
+		route, err = cfg.BuildRoute(route)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if err := route.Validate(); err != nil {
+		return nil, nil, err
+	}
+	if err := validateTemplates(route); err != nil {
+		return nil, nil, err
+	}
+	return route, files, nil
+}
+
+// optionalTemplate returns nil if the flag is not specified.
+func optionalTemplate(c *cli.Context, flag string) (*template.Template, error) {
+	s := c.String(flag)
+	if s == "" {
+		return nil, nil
+	}
+	t, err := templateFromString(s)
+	if err != nil {
+		return nil, fmt.Errorf("--%s: %w", flag, err)
+	}
+	return t, nil
+}
+
+func alertFilter(include []string, exclude []string) (*server.AlertFilter, error) {
+	if len(include) == 0 && len(exclude) == 0 {
+		return nil, nil
+	}
+	includeMatchers, err := matcher.ParseAll(include)
+	if err != nil {
+		return nil, fmt.Errorf("--%s: %w", flagIncludeAlerts, err)
+	}
+	excludeMatchers, err := matcher.ParseAll(exclude)
+	if err != nil {
+		return nil, fmt.Errorf("--%s: %w", flagExcludeAlerts, err)
+	}
+	return &server.AlertFilter{
+		Include: includeMatchers,
+		Exclude: excludeMatchers,
+	}, nil
+}
+
+func newWatchdog(c *cli.Context, nt notifier.Notifier) (*watchdog.Watchdog, error) {
+	matchers, err := matcher.ParseAll(c.StringSlice(flagWatchdogMatchers))
+	if err != nil {
+		return nil, fmt.Errorf("--%s: %w", flagWatchdogMatchers, err)
+	}
+
+	params := url.Values{}
+	if repository := c.String(flagWatchdogRepository); repository != "" {
+		owner, repo, ok := strings.Cut(repository, "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid repository %q: must be owner/repo", repository)
+		}
+		params.Set("owner", owner)
+		params.Set("repo", repo)
+	}
+
+	wd := &watchdog.Watchdog{
+		Matchers:      matchers,
+		Timeout:       c.Duration(flagWatchdogTimeout),
+		Notifier:      nt,
+		Params:        params,
+		NotifyTimeout: c.Duration(flagNotifyTimeout),
+	}
+	if dataDir := c.String(flagDataDir); dataDir != "" {
+		wd.Dir = filepath.Join(dataDir, "watchdog")
+	}
+	return wd, nil
+}
+
+func newSilencer(c *cli.Context, amURL string, githubClient *github.Client) (*alertmanager.Silencer, []byte, error) {
+	client, err := alertmanager.NewClient(amURL)
+	if err != nil {
+		return nil, nil, fmt.Errorf("--%s: %w", flagAlertmanagerURL, err)
+	}
+
+	secretFile := c.String(flagGitHubWebhookSecretFile)
+	if secretFile == "" {
+		return nil, nil, fmt.Errorf("--%s requires --%s", flagAlertmanagerURL, flagGitHubWebhookSecretFile)
+	}
+	b, err := os.ReadFile(secretFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	secret := bytes.TrimSpace(b)
+	if len(secret) == 0 {
+		return nil, nil, fmt.Errorf("--%s: %s is empty", flagGitHubWebhookSecretFile, secretFile)
+	}
+
+	return &alertmanager.Silencer{
+		Alertmanager: client,
+		GitHubClient: githubClient,
+		DryRun:       c.Bool(flagDryRun),
+	}, secret, nil
+}
+
+func readWebhookCredentials(bearerTokenFile string, basicAuthFile string) (*server.Credentials, error) {
+	credentials := &server.Credentials{}
+	if bearerTokenFile != "" {
+		tokens, err := server.ReadBearerTokenFile(bearerTokenFile)
+		if err != nil {
+			return nil, err
+		}
+		credentials.BearerTokens = tokens
+	}
+	if basicAuthFile != "" {
+		basicAuth, err := server.ReadBasicAuthFile(basicAuthFile)
+		if err != nil {
+			return nil, err
+		}
+		credentials.BasicAuth = basicAuth
+	}
+	return credentials, nil
+}
+
+func openIssueIndex(kind string, dataDir string) (index.Index, error) {
+	switch kind {
+	case "", issueIndexNone:
+		return nil, nil
+	case issueIndexMemory:
+		return index.NewMemory(), nil
+	case issueIndexFile:
+		if dataDir == "" {
+			return nil, fmt.Errorf("--%s=%s requires --%s", flagIssueIndex, issueIndexFile, flagDataDir)
+		}
+		if err := os.MkdirAll(dataDir, 0o700); err != nil {
+			return nil, err
+		}
+		return index.OpenBolt(filepath.Join(dataDir, "index.db"))
+	default:
+		return nil, fmt.Errorf("unknown issue index %q", kind)
+	}
 }
 
 func actionTestTemplate(c *cli.Context) error {
@@ -0,0 +1,227 @@
+package cli
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/google/go-github/v54/github"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/notifier"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/template"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+// Editors and Kubernetes ConfigMap updates produce several events for a single change.
+const reloadDelay = 500 * time.Millisecond
+
+var (
+	configLastReloadSuccessful = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "config_last_reload_successful",
+			Help: "Whether the last configuration reload attempt was successful.",
+		},
+	)
+	configLastReloadSuccessTimestamp = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "config_last_reload_success_timestamp_seconds",
+			Help: "Timestamp of the last successful configuration reload.",
+		},
+	)
+	configReloadCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "config_reloads_total",
+			Help: "Number of configuration reload attempts.",
+		},
+		// result: "success" or "failure"
+		[]string{"result"},
+	)
+)
+
+func configReloadSuccess(success bool) {
+	if success {
+		configLastReloadSuccessful.Set(1)
+		configLastReloadSuccessTimestamp.SetToCurrentTime()
+	} else {
+		configLastReloadSuccessful.Set(0)
+	}
+}
+
+// reloader reloads the route when the files it was loaded from change or SIGHUP is received.
+type reloader struct {
+	load  func() (*notifier.Route, []string, error)
+	apply func(*notifier.Route)
+
+	files []string
+}
+
+// reload keeps the current route if the new one fails to load.
+func (r *reloader) reload() error {
+	route, files, err := r.load()
+	if err != nil {
+		configReloadCount.WithLabelValues("failure").Inc()
+		configReloadSuccess(false)
+		return err
+	}
+
+	r.apply(route)
+	r.files = files
+	configReloadCount.WithLabelValues("success").Inc()
+	configReloadSuccess(true)
+	return nil
+}
+
+func (r *reloader) run(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := watcher.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to close file watcher")
+		}
+	}()
+	r.watch(watcher)
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-hup:
+			log.Info().Msg("reloading configuration on SIGHUP")
+			timer = time.After(0)
+		case event := <-watcher.Events:
+			if r.watched(event.Name) {
+				log.Debug().Str("file", event.Name).Str("op", event.Op.String()).Msg("configuration file changed")
+				timer = time.After(reloadDelay)
+			}
+		case err := <-watcher.Errors:
+			log.Error().Err(err).Msg("file watcher error")
+		case <-timer:
+			timer = nil
+			if err := r.reload(); err != nil {
+				log.Error().Err(err).Msg("failed to reload configuration, keeping the previous one")
+				continue
+			}
+			log.Info().Msg("reloaded configuration")
+			// The config file may refer to other template files now.
+			r.watch(watcher)
+		}
+	}
+}
+
+// watch watches the directories rather than the files, since files are often replaced rather than written.
+func (r *reloader) watch(watcher *fsnotify.Watcher) {
+	for _, file := range r.files {
+		dir := filepath.Dir(file)
+		if err := watcher.Add(dir); err != nil {
+			log.Error().Err(err).Str("dir", dir).Msg("failed to watch directory")
+		}
+	}
+}
+
+func (r *reloader) watched(name string) bool {
+	base := filepath.Base(name)
+	// Kubernetes updates mounted ConfigMaps by swapping the "..data" symlink.
+	if strings.HasPrefix(base, "..") {
+		return true
+	}
+	for _, file := range r.files {
+		if filepath.Clean(file) == filepath.Clean(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// validateTemplates executes all the templates in the route tree against a sample payload
+// so that broken templates are rejected before they are used.
+func validateTemplates(route *notifier.Route) error {
+	payload := &types.WebhookPayload{}
+	if err := json.Unmarshal([]byte(defaultPayload), payload); err != nil {
+		return err
+	}
+	return validateRouteTemplates(route, payload)
+}
+
+// validateRouteTemplates validates the templates as they are used by the route,
+// including the ones inherited from the parent routes.
+func validateRouteTemplates(route *notifier.Route, payload *types.WebhookPayload) error {
+	previousIssue := &github.Issue{
+		Number:    github.Int(1),
+		State:     github.String("closed"),
+		Title:     github.String("title"),
+		Body:      github.String("body"),
+		HTMLURL:   github.String("https://github.com/owner/repo/issues/1"),
+		CreatedAt: &github.Timestamp{Time: time.Now().Add(-time.Hour)},
+		ClosedAt:  &github.Timestamp{Time: time.Now()},
+	}
+
+	vars := &template.Vars{Payload: payload}
+	if route.IssuePerAlert != nil && *route.IssuePerAlert {
+		vars.Payload = payload.SubPayload(payload.Alerts[:1])
+		vars.Alert = &payload.Alerts[0]
+	}
+
+	templates := map[string]*template.Template{
+		"title":     route.TitleTemplate,
+		"body":      route.BodyTemplate,
+		"alert ID":  route.AlertIDTemplate,
+		"labels":    route.LabelsTemplate,
+		"assignees": route.AssigneesTemplate,
+		"milestone": route.MilestoneTemplate,
+	}
+	for name, tmpl := range templates {
+		if tmpl == nil {
+			continue
+		}
+		// The previous issue is nil unless issues are created again after the reopen window.
+		for _, issue := range []*github.Issue{nil, previousIssue} {
+			vars.PreviousIssue = issue
+			if _, err := tmpl.ExecuteVars(vars); err != nil {
+				return fmt.Errorf("%s template: %w", name, err)
+			}
+		}
+	}
+
+	if route.CommentTemplate != nil {
+		commentVars := *vars
+		commentVars.PreviousIssue = nil
+		commentVars.Changes = &types.AlertChanges{
+			Added:         vars.Payload.Alerts,
+			Resolved:      vars.Payload.Alerts,
+			GroupResolved: true,
+			GroupRefired:  true,
+		}
+		if _, err := route.CommentTemplate.ExecuteVars(&commentVars); err != nil {
+			return fmt.Errorf("comment template: %w", err)
+		}
+	}
+
+	for i, f := range route.LabelFamilies {
+		if _, err := f.Render(vars); err != nil {
+			return fmt.Errorf("label family %d template: %w", i, err)
+		}
+	}
+
+	for i, child := range route.Routes {
+		if err := validateRouteTemplates(child.Inherit(route), payload); err != nil {
+			return fmt.Errorf("routes[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
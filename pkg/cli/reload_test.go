@@ -0,0 +1,150 @@
+package cli
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/notifier"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/template"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func mustParseTemplate(t *testing.T, s string) *template.Template {
+	tmpl, err := template.Parse(s)
+	require.NoError(t, err)
+	return tmpl
+}
+
+func TestValidateTemplates(t *testing.T) {
+	valid := mustParseTemplate(t, "{{.Payload.GroupKey}}")
+
+	assert.NoError(t, validateTemplates(&notifier.Route{
+		TitleTemplate:   valid,
+		BodyTemplate:    mustParseTemplate(t, "{{if .PreviousIssue}}{{.PreviousIssue.HTMLURL}}{{end}}"),
+		AlertIDTemplate: valid,
+	}))
+
+	// Fails when there is no previous issue.
+	assert.Error(t, validateTemplates(&notifier.Route{
+		BodyTemplate: mustParseTemplate(t, "{{.PreviousIssue.HTMLURL}}"),
+	}))
+
+	assert.Error(t, validateTemplates(&notifier.Route{
+		TitleTemplate: valid,
+		Routes: []*notifier.Route{{
+			TitleTemplate: mustParseTemplate(t, "{{.Payload.Unknown}}"),
+		}},
+	}))
+
+	// Label family templates must render labels of their families.
+	assert.NoError(t, validateTemplates(&notifier.Route{
+		LabelFamilies: []*notifier.LabelFamily{{Prefix: "status/", Template: mustParseTemplate(t, "status/{{.Payload.Status}}")}},
+	}))
+	assert.Error(t, validateTemplates(&notifier.Route{
+		LabelFamilies: []*notifier.LabelFamily{{Prefix: "status/", Template: mustParseTemplate(t, "{{.Payload.Status}}")}},
+	}))
+
+	// Templates referring to the alert are only valid for routes creating an issue per alert.
+	alertTemplate := mustParseTemplate(t, "{{.Alert.Labels.labelKey1}}")
+	assert.NoError(t, validateTemplates(&notifier.Route{
+		Routes: []*notifier.Route{{
+			TitleTemplate: alertTemplate,
+			IssuePerAlert: github.Bool(true),
+		}},
+	}))
+	assert.Error(t, validateTemplates(&notifier.Route{
+		TitleTemplate: alertTemplate,
+		IssuePerAlert: github.Bool(true),
+		Routes: []*notifier.Route{{
+			IssuePerAlert: github.Bool(false),
+		}},
+	}))
+}
+
+// fileRouteLoader loads a route whose title template is read from a file.
+type fileRouteLoader struct {
+	path string
+
+	mu    sync.Mutex
+	route *notifier.Route
+}
+
+func (l *fileRouteLoader) load() (*notifier.Route, []string, error) {
+	b, err := os.ReadFile(l.path)
+	if err != nil {
+		return nil, nil, err
+	}
+	tmpl, err := template.Parse(string(b))
+	if err != nil {
+		return nil, nil, err
+	}
+	route := &notifier.Route{TitleTemplate: tmpl}
+	if err := validateTemplates(route); err != nil {
+		return nil, nil, err
+	}
+	return route, []string{l.path}, nil
+}
+
+func (l *fileRouteLoader) apply(route *notifier.Route) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.route = route
+}
+
+func (l *fileRouteLoader) title(t *testing.T) string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	s, err := l.route.TitleTemplate.Execute(nil, nil)
+	require.NoError(t, err)
+	return s
+}
+
+func TestReloaderKeepsLastGoodRoute(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "title.tmpl")
+	require.NoError(t, os.WriteFile(path, []byte("v1"), 0o600))
+	l := &fileRouteLoader{path: path}
+	r := &reloader{load: l.load, apply: l.apply}
+
+	require.NoError(t, r.reload())
+	assert.Equal(t, "v1", l.title(t))
+	assert.Equal(t, []string{path}, r.files)
+
+	require.NoError(t, os.WriteFile(path, []byte("{{"), 0o600))
+	assert.Error(t, r.reload())
+	assert.Equal(t, "v1", l.title(t))
+
+	require.NoError(t, os.WriteFile(path, []byte("{{.Payload.Unknown}}"), 0o600))
+	assert.Error(t, r.reload())
+	assert.Equal(t, "v1", l.title(t))
+}
+
+func TestReloaderWatchesFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "title.tmpl")
+	require.NoError(t, os.WriteFile(path, []byte("v1"), 0o600))
+	l := &fileRouteLoader{path: path}
+	r := &reloader{load: l.load, apply: l.apply}
+	require.NoError(t, r.reload())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		assert.NoError(t, r.run(ctx))
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	// Wait for the watcher to start.
+	assert.Eventually(t, func() bool {
+		require.NoError(t, os.WriteFile(path, []byte("v2"), 0o600))
+		return l.title(t) == "v2"
+	}, 10*time.Second, 2*reloadDelay)
+}
@@ -0,0 +1,250 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/matcher"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/notifier"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/template"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	// Route is the root route. Its unset fields are taken from the command line flags.
+	Route *Route `yaml:"route"`
+
+	// dir is used to resolve relative template file paths.
+	dir string
+}
+
+type Route struct {
+	// Alertmanager-style label matchers such as `severity="critical"`.
+	Matchers []string `yaml:"matchers"`
+
+	Owner             string   `yaml:"owner"`
+	Repo              string   `yaml:"repo"`
+	Labels            []string `yaml:"labels"`
+	TitleTemplate     string   `yaml:"title_template"`
+	TitleTemplateFile string   `yaml:"title_template_file"`
+	BodyTemplate      string   `yaml:"body_template"`
+	BodyTemplateFile  string   `yaml:"body_template_file"`
+	AlertIDTemplate   string   `yaml:"alert_id_template"`
+	LabelsTemplate    string   `yaml:"labels_template"`
+	AssigneesTemplate string   `yaml:"assignees_template"`
+	MilestoneTemplate string   `yaml:"milestone_template"`
+
+	LabelFamilies []*LabelFamily `yaml:"label_families"`
+
+	AutoCloseResolvedIssues *bool  `yaml:"auto_close_resolved_issues"`
+	AutoCloseDelay          string `yaml:"auto_close_delay"`
+	MinFiringDuration       string `yaml:"min_firing_duration"`
+	ReopenWindow            string `yaml:"reopen_window"`
+	ManualClosePolicy       string `yaml:"manual_close_policy"`
+	ManualCloseDuration     string `yaml:"manual_close_duration"`
+	IssuePerAlert           *bool  `yaml:"issue_per_alert"`
+	CommentOnChanges        *bool  `yaml:"comment_on_changes"`
+	CommentTemplate         string `yaml:"comment_template"`
+	CommentTemplateFile     string `yaml:"comment_template_file"`
+
+	Routes []*Route `yaml:"routes"`
+}
+
+// LabelFamily is a set of labels which replace each other. See notifier.LabelFamily.
+type LabelFamily struct {
+	Prefix   string   `yaml:"prefix"`
+	Labels   []string `yaml:"labels"`
+	Template string   `yaml:"template"`
+}
+
+func Load(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	cfg, err := Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	cfg.dir = filepath.Dir(path)
+	return cfg, nil
+}
+
+func Parse(b []byte) (*Config, error) {
+	cfg := &Config{}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(cfg); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// BuildRoute builds the route tree on top of defaultRoute, which is built from the command line flags.
+func (c *Config) BuildRoute(defaultRoute *notifier.Route) (*notifier.Route, error) {
+	if c.Route == nil {
+		return defaultRoute, nil
+	}
+	if len(c.Route.Matchers) > 0 {
+		return nil, fmt.Errorf("the root route must not have matchers")
+	}
+
+	root, err := c.buildRoute(c.Route, "route")
+	if err != nil {
+		return nil, err
+	}
+	return root.Inherit(defaultRoute), nil
+}
+
+func (c *Config) buildRoute(r *Route, name string) (*notifier.Route, error) {
+	matchers, err := matcher.ParseAll(r.Matchers)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", name, err)
+	}
+
+	route := &notifier.Route{
+		Matchers:                matchers,
+		Owner:                   r.Owner,
+		Repo:                    r.Repo,
+		Labels:                  r.Labels,
+		AutoCloseResolvedIssues: r.AutoCloseResolvedIssues,
+		IssuePerAlert:           r.IssuePerAlert,
+		CommentOnChanges:        r.CommentOnChanges,
+	}
+
+	if route.TitleTemplate, err = c.template(r.TitleTemplate, r.TitleTemplateFile); err != nil {
+		return nil, fmt.Errorf("%s: title template: %w", name, err)
+	}
+	if route.BodyTemplate, err = c.template(r.BodyTemplate, r.BodyTemplateFile); err != nil {
+		return nil, fmt.Errorf("%s: body template: %w", name, err)
+	}
+	if route.AlertIDTemplate, err = c.template(r.AlertIDTemplate, ""); err != nil {
+		return nil, fmt.Errorf("%s: alert ID template: %w", name, err)
+	}
+	if route.LabelsTemplate, err = c.template(r.LabelsTemplate, ""); err != nil {
+		return nil, fmt.Errorf("%s: labels template: %w", name, err)
+	}
+	if route.AssigneesTemplate, err = c.template(r.AssigneesTemplate, ""); err != nil {
+		return nil, fmt.Errorf("%s: assignees template: %w", name, err)
+	}
+	if route.MilestoneTemplate, err = c.template(r.MilestoneTemplate, ""); err != nil {
+		return nil, fmt.Errorf("%s: milestone template: %w", name, err)
+	}
+	if route.CommentTemplate, err = c.template(r.CommentTemplate, r.CommentTemplateFile); err != nil {
+		return nil, fmt.Errorf("%s: comment template: %w", name, err)
+	}
+
+	if r.LabelFamilies != nil {
+		route.LabelFamilies = []*notifier.LabelFamily{}
+	}
+	for i, f := range r.LabelFamilies {
+		family := &notifier.LabelFamily{
+			Prefix: f.Prefix,
+			Labels: f.Labels,
+		}
+		if f.Template != "" {
+			if family.Template, err = template.Parse(f.Template); err != nil {
+				return nil, fmt.Errorf("%s: label_families[%d]: %w", name, i, err)
+			}
+		}
+		if err := family.Validate(); err != nil {
+			return nil, fmt.Errorf("%s: label_families[%d]: %w", name, i, err)
+		}
+		route.LabelFamilies = append(route.LabelFamilies, family)
+	}
+
+	if r.AutoCloseDelay != "" {
+		d, err := time.ParseDuration(r.AutoCloseDelay)
+		if err != nil {
+			return nil, fmt.Errorf("%s: auto close delay: %w", name, err)
+		}
+		route.AutoCloseDelay = &d
+	}
+	if r.MinFiringDuration != "" {
+		d, err := time.ParseDuration(r.MinFiringDuration)
+		if err != nil {
+			return nil, fmt.Errorf("%s: min firing duration: %w", name, err)
+		}
+		route.MinFiringDuration = &d
+	}
+	if r.ReopenWindow != "" {
+		d, err := time.ParseDuration(r.ReopenWindow)
+		if err != nil {
+			return nil, fmt.Errorf("%s: reopen window: %w", name, err)
+		}
+		route.ReopenWindow = &d
+	}
+	if r.ManualClosePolicy != "" {
+		route.ManualClosePolicy, err = notifier.ParseManualClosePolicy(r.ManualClosePolicy)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	if r.ManualCloseDuration != "" {
+		d, err := time.ParseDuration(r.ManualCloseDuration)
+		if err != nil {
+			return nil, fmt.Errorf("%s: manual close duration: %w", name, err)
+		}
+		route.ManualCloseDuration = &d
+	}
+
+	for i, child := range r.Routes {
+		childRoute, err := c.buildRoute(child, fmt.Sprintf("%s.routes[%d]", name, i))
+		if err != nil {
+			return nil, err
+		}
+		route.Routes = append(route.Routes, childRoute)
+	}
+	return route, nil
+}
+
+// template returns nil if neither the template nor the file is specified.
+func (c *Config) template(s string, path string) (*template.Template, error) {
+	if s != "" && path != "" {
+		return nil, fmt.Errorf("both the template and the template file are specified")
+	}
+	if path != "" {
+		b, err := os.ReadFile(c.resolvePath(path))
+		if err != nil {
+			return nil, err
+		}
+		s = string(b)
+	}
+	if s == "" {
+		return nil, nil
+	}
+	return template.Parse(s)
+}
+
+// TemplateFiles returns the template files referenced by the routes.
+func (c *Config) TemplateFiles() []string {
+	var files []string
+	var walk func(r *Route)
+	walk = func(r *Route) {
+		for _, path := range []string{r.TitleTemplateFile, r.BodyTemplateFile, r.CommentTemplateFile} {
+			if path != "" {
+				files = append(files, c.resolvePath(path))
+			}
+		}
+		for _, child := range r.Routes {
+			walk(child)
+		}
+	}
+	if c.Route != nil {
+		walk(c.Route)
+	}
+	return files
+}
+
+func (c *Config) resolvePath(path string) string {
+	if filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(c.dir, path)
+}
@@ -0,0 +1,123 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/notifier"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/template"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoad(t *testing.T) {
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "db.tmpl"), []byte("db: {{.Payload.Status}}"), 0o600))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "config.yaml"), []byte(`
+route:
+  owner: foo
+  labels: [alert]
+  routes:
+    - matchers:
+        - team="db"
+        - severity=~"critical|warning"
+      repo: db-alerts
+      labels: []
+      body_template_file: db.tmpl
+      auto_close_resolved_issues: false
+      reopen_window: 24h
+      auto_close_delay: 10m
+      min_firing_duration: 5m
+      manual_close_policy: respect-for-duration
+      manual_close_duration: 2h
+      label_families:
+        - prefix: status/
+          template: "status/{{ .Payload.Status }}"
+        - labels: [critical, warning]
+          template: '{{ index .Payload.CommonLabels "severity" }}'
+      issue_per_alert: true
+    - matchers: ['team="web"']
+      repo: web-alerts
+      title_template: "[WEB] {{.Payload.GroupKey}}"
+      assignees_template: '{{ index .Payload.CommonAnnotations "owner" }}'
+`), 0o600))
+
+	cfg, err := Load(filepath.Join(dir, "config.yaml"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "db.tmpl")}, cfg.TemplateFiles())
+
+	defaultTemplate, err := template.Parse("default")
+	require.NoError(t, err)
+	route, err := cfg.BuildRoute(&notifier.Route{
+		Repo:            "bar",
+		Labels:          []string{"flag"},
+		TitleTemplate:   defaultTemplate,
+		BodyTemplate:    defaultTemplate,
+		AlertIDTemplate: defaultTemplate,
+	})
+	require.NoError(t, err)
+	require.NoError(t, route.Validate())
+
+	assert.Equal(t, "foo", route.Owner)
+	assert.Equal(t, "bar", route.Repo)
+	assert.Equal(t, []string{"alert"}, route.Labels)
+	assert.Same(t, defaultTemplate, route.TitleTemplate)
+	require.Len(t, route.Routes, 2)
+
+	db := route.Routes[0]
+	assert.Equal(t, `{team="db", severity=~"critical|warning"}`, db.Matchers.String())
+	assert.Equal(t, "db-alerts", db.Repo)
+	assert.Equal(t, []string{}, db.Labels)
+	assert.False(t, *db.AutoCloseResolvedIssues)
+	assert.Equal(t, 24*time.Hour, *db.ReopenWindow)
+	assert.Equal(t, 10*time.Minute, *db.AutoCloseDelay)
+	assert.Equal(t, 5*time.Minute, *db.MinFiringDuration)
+	assert.Equal(t, notifier.ManualCloseRespectForDuration, db.ManualClosePolicy)
+	assert.Equal(t, 2*time.Hour, *db.ManualCloseDuration)
+	assert.True(t, *db.IssuePerAlert)
+	require.Len(t, db.LabelFamilies, 2)
+	assert.Equal(t, "status/", db.LabelFamilies[0].Prefix)
+	assert.Equal(t, []string{"critical", "warning"}, db.LabelFamilies[1].Labels)
+	body, err := db.BodyTemplate.Execute(&types.WebhookPayload{Status: types.AlertStatusFiring}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "db: firing", body)
+
+	web := route.Routes[1]
+	assert.Nil(t, web.Labels)
+	assert.Nil(t, web.BodyTemplate)
+	assert.NotNil(t, web.TitleTemplate)
+	assert.Nil(t, web.LabelFamilies)
+	assert.NotNil(t, web.AssigneesTemplate)
+	assert.Nil(t, web.MilestoneTemplate)
+}
+
+func TestBuildRouteErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+	}{
+		{name: "unknown field", config: "route:\n  unknown: true\n"},
+		{name: "root matchers", config: "route:\n  matchers: ['a=\"b\"']\n"},
+		{name: "invalid matcher", config: "route:\n  routes:\n    - matchers: ['a']\n"},
+		{name: "invalid template", config: "route:\n  routes:\n    - title_template: '{{'\n"},
+		{name: "both template and file", config: "route:\n  body_template: a\n  body_template_file: b\n"},
+		{name: "invalid duration", config: "route:\n  reopen_window: 1x\n"},
+		{name: "invalid auto close delay", config: "route:\n  auto_close_delay: 1x\n"},
+		{name: "label family without members", config: "route:\n  label_families:\n    - template: a\n"},
+		{name: "label family without template", config: "route:\n  label_families:\n    - prefix: status/\n"},
+		{name: "invalid manual close policy", config: "route:\n  manual_close_policy: ignore\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg, err := Parse([]byte(tt.config))
+			if err == nil {
+				_, err = cfg.BuildRoute(&notifier.Route{})
+			}
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,70 @@
+package index
+
+import (
+	"encoding/json"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var issuesBucket = []byte("issues")
+
+// BoltIndex is an Index persisted in a BoltDB file.
+type BoltIndex struct {
+	db *bolt.DB
+}
+
+func OpenBolt(path string) (*BoltIndex, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: 10 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(issuesBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+
+	return &BoltIndex{db: db}, nil
+}
+
+func (i *BoltIndex) Get(owner, repo, alertID string) (*Entry, bool, error) {
+	var entry *Entry
+	err := i.db.View(func(tx *bolt.Tx) error {
+		v := tx.Bucket(issuesBucket).Get([]byte(key(owner, repo, alertID)))
+		if v == nil {
+			return nil
+		}
+		entry = &Entry{}
+		return json.Unmarshal(v, entry)
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return entry, entry != nil, nil
+}
+
+func (i *BoltIndex) Put(owner, repo, alertID string, entry *Entry) error {
+	v, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	return i.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(issuesBucket).Put([]byte(key(owner, repo, alertID)), v)
+	})
+}
+
+func (i *BoltIndex) Delete(owner, repo, alertID string) error {
+	return i.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(issuesBucket).Delete([]byte(key(owner, repo, alertID)))
+	})
+}
+
+func (i *BoltIndex) Close() error {
+	return i.db.Close()
+}
@@ -0,0 +1,66 @@
+package index
+
+import (
+	"sync"
+)
+
+// Entry points to the latest issue of an alert in a repository.
+type Entry struct {
+	Number int `json:"number"`
+	// PreviousNumber is the issue the latest one superseded, or 0 if there is no such issue.
+	PreviousNumber int `json:"previousNumber,omitempty"`
+}
+
+// Index maps alert IDs to issues so that they can be found without the Search API.
+type Index interface {
+	Get(owner, repo, alertID string) (*Entry, bool, error)
+	Put(owner, repo, alertID string, entry *Entry) error
+	Delete(owner, repo, alertID string) error
+	Close() error
+}
+
+func key(owner, repo, alertID string) string {
+	return owner + "/" + repo + "/" + alertID
+}
+
+type MemoryIndex struct {
+	mu      sync.RWMutex
+	entries map[string]Entry
+}
+
+func NewMemory() *MemoryIndex {
+	return &MemoryIndex{
+		entries: map[string]Entry{},
+	}
+}
+
+func (i *MemoryIndex) Get(owner, repo, alertID string) (*Entry, bool, error) {
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	e, ok := i.entries[key(owner, repo, alertID)]
+	if !ok {
+		return nil, false, nil
+	}
+	return &e, true, nil
+}
+
+func (i *MemoryIndex) Put(owner, repo, alertID string, entry *Entry) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	i.entries[key(owner, repo, alertID)] = *entry
+	return nil
+}
+
+func (i *MemoryIndex) Delete(owner, repo, alertID string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	delete(i.entries, key(owner, repo, alertID))
+	return nil
+}
+
+func (i *MemoryIndex) Close() error {
+	return nil
+}
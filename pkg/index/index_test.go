@@ -0,0 +1,74 @@
+package index
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIndex(t *testing.T) {
+	tests := []struct {
+		name string
+		open func(t *testing.T) Index
+	}{
+		{
+			name: "memory",
+			open: func(t *testing.T) Index {
+				return NewMemory()
+			},
+		},
+		{
+			name: "bolt",
+			open: func(t *testing.T) Index {
+				i, err := OpenBolt(filepath.Join(t.TempDir(), "index.db"))
+				require.NoError(t, err)
+				return i
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			i := tt.open(t)
+			defer func() { assert.NoError(t, i.Close()) }()
+
+			_, ok, err := i.Get("foo", "bar", "alert1")
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			require.NoError(t, i.Put("foo", "bar", "alert1", &Entry{Number: 2, PreviousNumber: 1}))
+			e, ok, err := i.Get("foo", "bar", "alert1")
+			require.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, &Entry{Number: 2, PreviousNumber: 1}, e)
+
+			_, ok, err = i.Get("foo", "baz", "alert1")
+			require.NoError(t, err)
+			assert.False(t, ok)
+
+			require.NoError(t, i.Delete("foo", "bar", "alert1"))
+			_, ok, err = i.Get("foo", "bar", "alert1")
+			require.NoError(t, err)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestBoltIndexPersists(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "index.db")
+
+	i, err := OpenBolt(path)
+	require.NoError(t, err)
+	require.NoError(t, i.Put("foo", "bar", "alert1", &Entry{Number: 3}))
+	require.NoError(t, i.Close())
+
+	i, err = OpenBolt(path)
+	require.NoError(t, err)
+	defer func() { assert.NoError(t, i.Close()) }()
+	e, ok, err := i.Get("foo", "bar", "alert1")
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 3, e.Number)
+}
@@ -0,0 +1,143 @@
+package matcher
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type Type int
+
+const (
+	MatchEqual Type = iota
+	MatchNotEqual
+	MatchRegexp
+	MatchNotRegexp
+)
+
+func (t Type) String() string {
+	switch t {
+	case MatchEqual:
+		return "="
+	case MatchNotEqual:
+		return "!="
+	case MatchRegexp:
+		return "=~"
+	case MatchNotRegexp:
+		return "!~"
+	}
+	return "unknown"
+}
+
+// Matcher matches a label value in the same way as Alertmanager.
+// A missing label is treated as an empty value.
+type Matcher struct {
+	Type  Type
+	Name  string
+	Value string
+
+	re *regexp.Regexp
+}
+
+func New(t Type, name, value string) (*Matcher, error) {
+	m := &Matcher{Type: t, Name: name, Value: value}
+	if t == MatchRegexp || t == MatchNotRegexp {
+		// Regular expressions are fully anchored as in Alertmanager.
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, err
+		}
+		m.re = re
+	}
+	return m, nil
+}
+
+var matcherRegexp = regexp.MustCompile(`^\s*([a-zA-Z_][a-zA-Z0-9_]*)\s*(=~|!~|!=|=)\s*(.*?)\s*$`)
+
+// Parse parses a matcher such as `severity="critical"` or `team=~"db|storage"`.
+// The value may be unquoted.
+func Parse(s string) (*Matcher, error) {
+	m := matcherRegexp.FindStringSubmatch(s)
+	if m == nil {
+		return nil, fmt.Errorf("invalid matcher %q", s)
+	}
+
+	var t Type
+	switch m[2] {
+	case "=":
+		t = MatchEqual
+	case "!=":
+		t = MatchNotEqual
+	case "=~":
+		t = MatchRegexp
+	case "!~":
+		t = MatchNotRegexp
+	}
+
+	value := m[3]
+	if strings.HasPrefix(value, `"`) {
+		v, err := strconv.Unquote(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid matcher %q: %w", s, err)
+		}
+		value = v
+	}
+
+	matcher, err := New(t, m[1], value)
+	if err != nil {
+		return nil, fmt.Errorf("invalid matcher %q: %w", s, err)
+	}
+	return matcher, nil
+}
+
+func (m *Matcher) Matches(value string) bool {
+	switch m.Type {
+	case MatchEqual:
+		return value == m.Value
+	case MatchNotEqual:
+		return value != m.Value
+	case MatchRegexp:
+		return m.re.MatchString(value)
+	case MatchNotRegexp:
+		return !m.re.MatchString(value)
+	}
+	return false
+}
+
+func (m *Matcher) String() string {
+	return fmt.Sprintf("%s%s%q", m.Name, m.Type, m.Value)
+}
+
+// Matchers matches labels if all of the matchers match.
+type Matchers []*Matcher
+
+// ParseAll parses each of the strings as a matcher.
+func ParseAll(ss []string) (Matchers, error) {
+	var ms Matchers
+	for _, s := range ss {
+		m, err := Parse(s)
+		if err != nil {
+			return nil, err
+		}
+		ms = append(ms, m)
+	}
+	return ms, nil
+}
+
+func (ms Matchers) Matches(labels map[string]string) bool {
+	for _, m := range ms {
+		if !m.Matches(labels[m.Name]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (ms Matchers) String() string {
+	s := make([]string, 0, len(ms))
+	for _, m := range ms {
+		s = append(s, m.String())
+	}
+	return "{" + strings.Join(s, ", ") + "}"
+}
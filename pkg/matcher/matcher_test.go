@@ -0,0 +1,56 @@
+package matcher
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected *Matcher
+		err      bool
+	}{
+		{input: `severity="critical"`, expected: &Matcher{Type: MatchEqual, Name: "severity", Value: "critical"}},
+		{input: ` severity != critical `, expected: &Matcher{Type: MatchNotEqual, Name: "severity", Value: "critical"}},
+		{input: `team=~"db|storage"`, expected: &Matcher{Type: MatchRegexp, Name: "team", Value: "db|storage"}},
+		{input: `team!~db`, expected: &Matcher{Type: MatchNotRegexp, Name: "team", Value: "db"}},
+		{input: `msg="a \"quoted\" value"`, expected: &Matcher{Type: MatchEqual, Name: "msg", Value: `a "quoted" value`}},
+		{input: `env=""`, expected: &Matcher{Type: MatchEqual, Name: "env", Value: ""}},
+		{input: `severity`, err: true},
+		{input: `1severity="critical"`, err: true},
+		{input: `severity="critical`, err: true},
+		{input: `team=~"("`, err: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.input, func(t *testing.T) {
+			m, err := Parse(tt.input)
+			if tt.err {
+				assert.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tt.expected.Type, m.Type)
+			assert.Equal(t, tt.expected.Name, m.Name)
+			assert.Equal(t, tt.expected.Value, m.Value)
+		})
+	}
+}
+
+func TestMatchers(t *testing.T) {
+	ms, err := ParseAll([]string{`severity=~"critical|warning"`, `team!="db"`, `env=""`})
+	require.NoError(t, err)
+
+	assert.True(t, ms.Matches(map[string]string{"severity": "critical", "team": "web"}))
+	assert.True(t, ms.Matches(map[string]string{"severity": "warning"}))
+	// Regular expressions are anchored.
+	assert.False(t, ms.Matches(map[string]string{"severity": "critical-ish"}))
+	assert.False(t, ms.Matches(map[string]string{"severity": "critical", "team": "db"}))
+	assert.False(t, ms.Matches(map[string]string{"severity": "critical", "env": "prod"}))
+
+	assert.True(t, Matchers(nil).Matches(map[string]string{"severity": "critical"}))
+	assert.Equal(t, `{severity=~"critical|warning", team!="db", env=""}`, ms.String())
+}
@@ -0,0 +1,108 @@
+package notifier
+
+import (
+	"github.com/google/go-github/v54/github"
+)
+
+// Acknowledgment tells whether humans have acknowledged an issue. While an issue is acknowledged,
+// its body is not rewritten and it is not reopened, and changes of the alerts are posted as comments instead.
+// The acknowledgment is cleared when the alert group resolves.
+type Acknowledgment struct {
+	// If set, issues with Label are acknowledged.
+	Label string
+	// If true, issues with assignees are acknowledged.
+	Assignees bool
+}
+
+// acknowledged returns whether the issue is acknowledged. It is nil-safe.
+func (a *Acknowledgment) acknowledged(issue *github.Issue, lastState *issueState) bool {
+	if a == nil || issue == nil {
+		return false
+	}
+	return a.byLabel(issue) || len(a.byAssignees(issue, lastState)) > 0
+}
+
+// byLabel returns whether the issue is acknowledged with the label.
+func (a *Acknowledgment) byLabel(issue *github.Issue) bool {
+	if a.Label == "" {
+		return false
+	}
+	for _, l := range issue.Labels {
+		if l.GetName() == a.Label {
+			return true
+		}
+	}
+	return false
+}
+
+// byAssignees returns the assignees acknowledging the issue.
+// The assignees recorded in the last state were set by the receiver, so they do not acknowledge the issue.
+func (a *Acknowledgment) byAssignees(issue *github.Issue, lastState *issueState) []string {
+	if !a.Assignees {
+		return nil
+	}
+	own := map[string]bool{}
+	if lastState != nil {
+		for _, login := range lastState.Assignees {
+			own[login] = true
+		}
+	}
+	var logins []string
+	for _, u := range issue.Assignees {
+		if login := u.GetLogin(); !own[login] {
+			logins = append(logins, login)
+		}
+	}
+	return logins
+}
+
+// ownAssignees returns the assignees which do not acknowledge the issue: the ones rendered now,
+// and the ones recorded in the last state which are still assigned.
+func ownAssignees(issue *github.Issue, lastState *issueState, rendered []string) []string {
+	if issue == nil || lastState == nil {
+		return rendered
+	}
+	assigned := map[string]bool{}
+	for _, u := range issue.Assignees {
+		assigned[u.GetLogin()] = true
+	}
+	var own []string
+	seen := map[string]bool{}
+	for _, login := range lastState.Assignees {
+		if assigned[login] && !seen[login] {
+			seen[login] = true
+			own = append(own, login)
+		}
+	}
+	for _, login := range rendered {
+		if !seen[login] {
+			seen[login] = true
+			own = append(own, login)
+		}
+	}
+	return own
+}
+
+// clearedAssignees returns the assignees the issue keeps when its acknowledgment is cleared.
+// If the label acknowledged the issue, removing it clears the acknowledgment, and the human assignees are left alone.
+// Otherwise, the assignees who acknowledged the issue are removed.
+func (a *Acknowledgment) clearedAssignees(issue *github.Issue, lastState *issueState, rendered []string) []string {
+	merged := mergeAssignees(issue, rendered)
+	if a.byLabel(issue) {
+		return merged
+	}
+	removed := map[string]bool{}
+	for _, login := range a.byAssignees(issue, lastState) {
+		removed[login] = true
+	}
+	for _, login := range rendered {
+		delete(removed, login)
+	}
+	kept := []string{}
+	for _, login := range merged {
+		if !removed[login] {
+			kept = append(kept, login)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,30 @@
+package notifier
+
+import (
+	"strings"
+)
+
+// The rendered body is kept between these markers so that what humans write outside them is preserved.
+const (
+	managedSectionBegin = "<!-- BEGIN ALERTMANAGER-TO-GITHUB MANAGED SECTION, DO NOT EDIT -->\n"
+	managedSectionEnd   = "\n<!-- END ALERTMANAGER-TO-GITHUB MANAGED SECTION -->\n"
+)
+
+func managedSection(content string) string {
+	return managedSectionBegin + content + managedSectionEnd
+}
+
+// replaceManagedSection replaces the managed section of the body with the content.
+// It returns false if the body has no managed section, e.g. it was created by an older version.
+func replaceManagedSection(body string, content string) (string, bool) {
+	begin := strings.Index(body, managedSectionBegin)
+	if begin < 0 {
+		return "", false
+	}
+	end := strings.Index(body[begin:], managedSectionEnd)
+	if end < 0 {
+		return "", false
+	}
+	end += begin + len(managedSectionEnd)
+	return body[:begin] + managedSection(content) + body[end:], true
+}
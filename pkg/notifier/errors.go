@@ -0,0 +1,43 @@
+package notifier
+
+import "errors"
+
+// permanentError is an error which retrying the same payload cannot fix, such as a payload without a repository.
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string {
+	return e.err.Error()
+}
+
+func (e *permanentError) Unwrap() error {
+	return e.err
+}
+
+// permanent marks err as permanent. It returns nil if err is nil.
+func permanent(err error) error {
+	if err == nil {
+		return nil
+	}
+	return &permanentError{err: err}
+}
+
+// IsPermanent returns true if retrying the payload which failed with err cannot succeed.
+// An error joined from several errors is permanent only if all of them are.
+func IsPermanent(err error) bool {
+	if err == nil {
+		return false
+	}
+	if joined, ok := err.(interface{ Unwrap() []error }); ok {
+		errs := joined.Unwrap()
+		for _, err := range errs {
+			if !IsPermanent(err) {
+				return false
+			}
+		}
+		return len(errs) > 0
+	}
+	var p *permanentError
+	return errors.As(err, &p)
+}
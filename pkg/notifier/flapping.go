@@ -0,0 +1,187 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	flappingAlerts = promauto.NewGauge(
+		prometheus.GaugeOpts{
+			Name: "flapping_alerts",
+			Help: "Number of alert IDs which are currently flapping.",
+		},
+	)
+	flappingDetectedCount = promauto.NewCounter(
+		prometheus.CounterOpts{
+			Name: "flapping_detected_total",
+			Help: "Number of times alert IDs started flapping.",
+		},
+	)
+)
+
+// FlappingDetector counts the transitions between firing and resolved of each alert ID.
+// While an alert is flapping, its issue is kept open and labeled instead of being closed and reopened.
+type FlappingDetector struct {
+	// Transitions are counted over Window.
+	Window time.Duration
+	// The alert is flapping once it has transitioned Threshold times within Window.
+	Threshold int
+	// The alert stops flapping once it has not transitioned for StablePeriod.
+	StablePeriod time.Duration
+	// Label added to the issues of flapping alerts.
+	Label string
+
+	mu     sync.Mutex
+	alerts map[string]*flappingState
+}
+
+type flappingState struct {
+	status         types.AlertStatus
+	transitions    []time.Time
+	lastTransition time.Time
+	lastSeen       time.Time
+	flapping       bool
+	// The last notification, to notify again once the alert is stable.
+	notification *notification
+}
+
+// flappingObservation is the state of an alert ID after a notification, which is committed once the notification succeeds.
+// Otherwise the notification is retried, and the transition must be observed again, for example to post the comment once.
+type flappingObservation struct {
+	alertID string
+	state   flappingState
+	// Whether the alert is flapping, and whether it has just started to.
+	flapping bool
+	started  bool
+	// Whether the alert has just stopped flapping.
+	stopped bool
+}
+
+// observe returns the state of the alert ID with the status of the notification, without recording it.
+func (d *FlappingDetector) observe(nf *notification, now time.Time) *flappingObservation {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.alerts == nil {
+		d.alerts = map[string]*flappingState{}
+	}
+	d.expire(now)
+
+	o := &flappingObservation{alertID: nf.alertID}
+	s := &o.state
+	if last, ok := d.alerts[nf.alertID]; ok {
+		*s = *last
+		s.transitions = append([]time.Time(nil), last.transitions...)
+	} else {
+		s.status = nf.payload.Status
+	}
+	s.notification = nf
+	s.lastSeen = now
+
+	if s.status != nf.payload.Status {
+		s.status = nf.payload.Status
+		s.transitions = append(s.transitions, now)
+		s.lastTransition = now
+	}
+	for len(s.transitions) > 0 && now.Sub(s.transitions[0]) > d.Window {
+		s.transitions = s.transitions[1:]
+	}
+
+	if !s.flapping && len(s.transitions) >= d.Threshold {
+		s.flapping = true
+		o.started = true
+	} else if s.flapping && now.Sub(s.lastTransition) >= d.StablePeriod {
+		s.flapping = false
+		s.transitions = nil
+		o.stopped = true
+	}
+	o.flapping = s.flapping
+	return o
+}
+
+// commit records the observed state after the notification has succeeded.
+func (d *FlappingDetector) commit(o *flappingObservation) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.alerts == nil {
+		d.alerts = map[string]*flappingState{}
+	}
+	d.alerts[o.alertID] = &o.state
+	if o.started {
+		flappingAlerts.Inc()
+		flappingDetectedCount.Inc()
+	} else if o.stopped {
+		flappingAlerts.Dec()
+	}
+}
+
+// expire forgets the alerts which are not flapping and have not been notified within the window.
+// Their transitions would not be counted anyway.
+func (d *FlappingDetector) expire(now time.Time) {
+	for alertID, s := range d.alerts {
+		if !s.flapping && now.Sub(s.lastSeen) > d.Window {
+			delete(d.alerts, alertID)
+		}
+	}
+}
+
+// stable returns the last notifications of the flapping alerts which have been stable for StablePeriod.
+func (d *FlappingDetector) stable(now time.Time) []*notification {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var nfs []*notification
+	for _, s := range d.alerts {
+		if s.flapping && now.Sub(s.lastTransition) >= d.StablePeriod {
+			nfs = append(nfs, s.notification)
+		}
+	}
+	return nfs
+}
+
+func (d *FlappingDetector) comment() string {
+	return fmt.Sprintf("This alert is flapping: it has changed between firing and resolved %d times within %s. "+
+		"The issue is kept open and labeled `%s` until the alert has been stable for %s.",
+		d.Threshold, d.Window, d.Label, d.StablePeriod)
+}
+
+// RunFlappingDetector notifies flapping alerts again once they are stable,
+// since Alertmanager does not repeat notifications of resolved alerts.
+// It returns when ctx is canceled, after finishing the notification in progress, which is bounded by NotifyTimeout.
+func (n *GitHubNotifier) RunFlappingDetector(ctx context.Context, interval time.Duration) {
+	if n.Flapping == nil {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			for _, nf := range n.Flapping.stable(time.Now()) {
+				if ctx.Err() != nil {
+					return
+				}
+				notifyCtx, cancel := n.backgroundContext(ctx)
+				err := n.locks.do(notifyCtx, nf.alertID, "flapping", func() error {
+					return n.notify(notifyCtx, nf)
+				})
+				cancel()
+				if err != nil {
+					log.Error().Err(err).Str("alertID", nf.alertID).Msg("failed to notify a stable alert")
+				}
+			}
+		}
+	}
+}
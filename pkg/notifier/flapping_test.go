@@ -0,0 +1,50 @@
+package notifier
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFlappingDetector(t *testing.T) {
+	d := &FlappingDetector{Window: time.Hour, Threshold: 3, StablePeriod: 30 * time.Minute, Label: "flapping"}
+	now := time.Now()
+	observe := func(status types.AlertStatus, at time.Duration) (bool, bool) {
+		o := d.observe(&notification{alertID: "id", payload: testPayload(status)}, now.Add(at))
+		d.commit(o)
+		return o.flapping, o.started
+	}
+
+	assertObserve := func(flapping, started bool, status types.AlertStatus, at time.Duration) {
+		t.Helper()
+		f, s := observe(status, at)
+		assert.Equal(t, flapping, f)
+		assert.Equal(t, started, s)
+	}
+	assertObserve(false, false, types.AlertStatusFiring, 0)
+	assertObserve(false, false, types.AlertStatusResolved, time.Minute)
+	// Repeated notifications are not transitions.
+	assertObserve(false, false, types.AlertStatusResolved, 2*time.Minute)
+	assertObserve(false, false, types.AlertStatusFiring, 3*time.Minute)
+	// The transition is not recorded until the notification succeeds, so it starts flapping again after a failure.
+	o := d.observe(&notification{alertID: "id", payload: testPayload(types.AlertStatusResolved)}, now.Add(4*time.Minute))
+	assert.True(t, o.started)
+	assertObserve(true, true, types.AlertStatusResolved, 4*time.Minute)
+	assertObserve(true, false, types.AlertStatusFiring, 5*time.Minute)
+
+	assert.Empty(t, d.stable(now.Add(30*time.Minute)))
+	assert.Len(t, d.stable(now.Add(35*time.Minute)), 1)
+	// The stable alert is notified again until the notification succeeds.
+	o = d.observe(&notification{alertID: "id", payload: testPayload(types.AlertStatusFiring)}, now.Add(35*time.Minute))
+	assert.False(t, o.flapping)
+	assert.Len(t, d.stable(now.Add(36*time.Minute)), 1)
+	assertObserve(false, false, types.AlertStatusFiring, 36*time.Minute)
+	assert.Empty(t, d.stable(now.Add(37*time.Minute)))
+
+	// Transitions out of the window are not counted.
+	assertObserve(false, false, types.AlertStatusResolved, 2*time.Hour)
+	assertObserve(false, false, types.AlertStatusFiring, 3*time.Hour+time.Minute)
+	assertObserve(false, false, types.AlertStatusResolved, 4*time.Hour+2*time.Minute)
+}
@@ -3,14 +3,19 @@ package notifier
 import (
 	"context"
 	"crypto/sha256"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"net/url"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/google/go-github/v54/github"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/index"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/scheduler"
 	"github.com/pfnet-research/alertmanager-to-github/pkg/template"
 	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
 	"github.com/prometheus/client_golang/prometheus"
@@ -21,6 +26,8 @@ import (
 const (
 	ownerLabelName = "atg_owner"
 	repoLabelName  = "atg_repo"
+
+	alertIDMarkerFormat = "\n<!-- (UNIQUE ALERT ID, DO NOT MODIFY: %s ) -->\n"
 )
 
 var (
@@ -60,93 +67,199 @@ var (
 )
 
 type GitHubNotifier struct {
-	GitHubClient            *github.Client
-	BodyTemplate            *template.Template
-	TitleTemplate           *template.Template
-	AlertIDTemplate         *template.Template
-	Labels                  []string
-	AutoCloseResolvedIssues bool
-	ReopenWindow            *time.Duration
+	GitHubClient *github.Client
+	// If set, issues are looked up in Index before falling back to the Search API.
+	Index index.Index
+	// If set, issues are only created in the repositories allowed by the policy.
+	RepositoryPolicy *RepositoryPolicy
+	// How long the result of CheckReady is reused.
+	ReadinessCacheTTL time.Duration
+	// If true, GitHub is only read, and the writes are recorded as plans instead.
+	DryRun bool
+	// If set, plans are recorded in Plans in dry-run mode.
+	Plans *PlanRecorder
+	// If set, flapping alerts keep their issues open.
+	Flapping *FlappingDetector
+	// If set, closing issues can be delayed.
+	Scheduler *scheduler.Scheduler
+	// If set, acknowledged issues are not rewritten or reopened.
+	Acknowledgment *Acknowledgment
+	// How long the labels, milestones and assignees of repositories are cached.
+	MetadataCacheTTL time.Duration
+	// Bounds the notifications made in the background by scheduled tasks and the flapping detector. 0 means no timeout.
+	NotifyTimeout time.Duration
+
+	// route is the root of the routes which decide how issues are created.
+	// It is swapped when the configuration is reloaded.
+	route     atomic.Pointer[Route]
+	locks     alertLocks
+	readiness readiness
+	metadata  repositoryMetadata
 }
 
 func NewGitHub() (*GitHubNotifier, error) {
 	return &GitHubNotifier{}, nil
 }
 
-func resolveRepository(payload *types.WebhookPayload, queryParams url.Values) (string, string, error) {
-	owner := queryParams.Get("owner")
-	repo := queryParams.Get("repo")
+// Route returns the root route.
+func (n *GitHubNotifier) Route() *Route {
+	return n.route.Load()
+}
 
-	if payload.CommonLabels[ownerLabelName] != "" {
-		owner = payload.CommonLabels[ownerLabelName]
-	}
-	if payload.CommonLabels[repoLabelName] != "" {
-		repo = payload.CommonLabels[repoLabelName]
-	}
-	if owner == "" {
-		return "", "", fmt.Errorf("owner was not specified in either the webhook URL, or the alert labels")
-	}
-	if repo == "" {
-		return "", "", fmt.Errorf("repo was not specified in either the webhook URL, or the alert labels")
-	}
-	return owner, repo, nil
+// SetRoute replaces the root route. Notifications in progress keep using the previous one.
+func (n *GitHubNotifier) SetRoute(route *Route) {
+	n.route.Store(route)
 }
 
 func isClosed(issue *github.Issue) bool {
 	return issue != nil && issue.GetState() == "closed"
 }
 
+// notification creates or updates a single issue.
+type notification struct {
+	payload *types.WebhookPayload
+	// alert is the alert the issue is for, or nil if the issue is for the whole group.
+	alert   *types.WebhookAlert
+	route   *Route
+	owner   string
+	repo    string
+	alertID string
+	// note is prepended to the issue body.
+	note   string
+	params url.Values
+	// routeLabels are the common labels of the whole group, which the route was resolved from.
+	routeLabels map[string]string
+	// scheduled is true if the notification is made by a scheduled task, whose delay has elapsed.
+	scheduled bool
+}
+
+func (nf *notification) templateVars(previousIssue *github.Issue) *template.Vars {
+	return &template.Vars{
+		Payload:       nf.payload,
+		PreviousIssue: previousIssue,
+		Alert:         nf.alert,
+	}
+}
+
 func (n *GitHubNotifier) Notify(ctx context.Context, payload *types.WebhookPayload, queryParams url.Values) error {
-	owner, repo, err := resolveRepository(payload, queryParams)
-	if err != nil {
-		return err
+	route := resolveRoute(n.Route(), payload, queryParams)
+
+	// Alerts in a group may be targeted at different repositories.
+	targets, err := splitByRepository(payload, route)
+	errs := []error{permanent(err)}
+
+	var notifications []*notification
+	for _, t := range targets {
+		owner, repo, note, err := n.checkRepositoryPolicy(t.owner, t.repo)
+		if err != nil {
+			errs = append(errs, permanent(err))
+			continue
+		}
+
+		nfs, err := newNotifications(t.payload, route, owner, repo, note)
+		if err != nil {
+			errs = append(errs, permanent(err))
+			continue
+		}
+		for _, nf := range nfs {
+			nf.params = queryParams
+			nf.routeLabels = payload.CommonLabels
+		}
+		notifications = append(notifications, nfs...)
 	}
 
-	labels := n.Labels
-	if l := queryParams.Get("labels"); l != "" {
-		labels = strings.Split(l, ",")
+	for _, nf := range notifications {
+		// Identical payloads are delivered at the same time by Alertmanager HA pairs.
+		// Process them only once, and never process the same alert concurrently.
+		key, err := coalesceKey(nf.payload, queryParams)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		err = n.locks.do(ctx, nf.alertID, key, func() error {
+			return n.notify(ctx, nf)
+		})
+		if err != nil {
+			errs = append(errs, err)
+		}
 	}
+	return errors.Join(errs...)
+}
 
-	alertID, err := n.getAlertID(payload)
-	if err != nil {
-		return err
+// checkRepositoryPolicy returns the repository to create issues in, and a note for the issue body if it is the fallback repository.
+func (n *GitHubNotifier) checkRepositoryPolicy(owner, repo string) (string, string, string, error) {
+	err := n.RepositoryPolicy.Check(owner, repo)
+	if err == nil {
+		return owner, repo, "", nil
+	}
+	if !n.RepositoryPolicy.hasFallback() {
+		repositoryPolicyViolationCount.WithLabelValues("rejected").Inc()
+		return "", "", "", err
 	}
 
-	query := fmt.Sprintf(`repo:%s/%s "%s"`, owner, repo, alertID)
-	searchResult, response, err := n.GitHubClient.Search.Issues(ctx, query, &github.SearchOptions{
-		TextMatch: true,
-		Sort:      "created",
-		Order:     "desc",
-	})
-	if err != nil {
-		return err
+	repositoryPolicyViolationCount.WithLabelValues("fallback").Inc()
+	log.Warn().Err(err).Str("owner", owner).Str("repo", repo).Msg("creating the issue in the fallback repository")
+	note := fmt.Sprintf("> [!WARNING]\n> This alert was targeted at `%s/%s`, which is not allowed by the repository policy.\n\n", owner, repo)
+	return n.RepositoryPolicy.FallbackOwner, n.RepositoryPolicy.FallbackRepo, note, nil
+}
+
+// newNotifications returns a notification for the group, or for each alert if the route creates an issue per alert.
+func newNotifications(payload *types.WebhookPayload, route *Route, owner, repo, note string) ([]*notification, error) {
+	if !route.issuePerAlert() {
+		alertID, err := getAlertID(route, payload)
+		if err != nil {
+			return nil, err
+		}
+		return []*notification{{
+			payload: payload,
+			route:   route,
+			owner:   owner,
+			repo:    repo,
+			alertID: alertID,
+			note:    note,
+		}}, nil
 	}
 
-	updateGithubApiMetrics("search", response)
-	if err = checkSearchResponse(response); err != nil {
-		return err
+	var notifications []*notification
+	for i := range payload.Alerts {
+		alert := &payload.Alerts[i]
+		notifications = append(notifications, &notification{
+			payload: payload.SubPayload([]types.WebhookAlert{*alert}),
+			alert:   alert,
+			route:   route,
+			owner:   owner,
+			repo:    repo,
+			alertID: alert.Fingerprint(),
+			note:    note,
+		})
 	}
+	return notifications, nil
+}
 
-	issues := searchResult.Issues
-	sort.Slice(issues, func(i, j int) bool {
-		return issues[i].GetCreatedAt().After(issues[j].GetCreatedAt().Time)
-	})
+func (n *GitHubNotifier) notify(ctx context.Context, nf *notification) (err error) {
+	payload, route, owner, repo, alertID := nf.payload, nf.route, nf.owner, nf.repo, nf.alertID
 
-	var issue, previousIssue *github.Issue
-	if len(issues) == 1 {
-		issue = issues[0]
-	} else if len(issues) > 1 {
-		issue = issues[0]
-		previousIssue = issues[1]
-		if n.ReopenWindow == nil {
-			// If issues are always reopened, the search result is expected to be unique.
-			log.Warn().Interface("searchResultTotal", searchResult.GetTotal()).
-				Str("groupKey", payload.GroupKey).Msg("too many search result")
-		}
+	ctx, finishPlan := n.startPlan(ctx, payload, owner, repo, alertID)
+	defer finishPlan()
+
+	var flapping, flappingStarted bool
+	if n.Flapping != nil {
+		observation := n.Flapping.observe(nf, time.Now())
+		flapping, flappingStarted = observation.flapping, observation.started
+		defer func() {
+			if err == nil {
+				n.Flapping.commit(observation)
+			}
+		}()
 	}
 
-	if n.ReopenWindow != nil && issue != nil && isClosed(issue) && payload.Status == types.AlertStatusFiring {
-		deadline := issue.GetClosedAt().Add(*n.ReopenWindow)
+	issue, previousIssue, indexed, err := n.findIssues(ctx, owner, repo, alertID, route.ReopenWindow)
+	if err != nil {
+		return err
+	}
+
+	if route.ReopenWindow != nil && issue != nil && isClosed(issue) && payload.Status == types.AlertStatusFiring {
+		deadline := issue.GetClosedAt().Add(*route.ReopenWindow)
 		if time.Now().After(deadline) {
 			// A new issue will be created instead of reopening the existing issue.
 			previousIssue = issue
@@ -154,13 +267,88 @@ func (n *GitHubNotifier) Notify(ctx context.Context, payload *types.WebhookPaylo
 		}
 	}
 
-	body, err := n.BodyTemplate.Execute(payload, previousIssue)
+	desiredState, err := desiredIssueState(payload)
 	if err != nil {
 		return err
 	}
-	body += fmt.Sprintf("\n<!-- (UNIQUE ALERT ID, DO NOT MODIFY: %s ) -->\n", alertID)
+	canUpdateState := desiredState == "open" || shouldAutoCloseIssue(route, payload)
+	if payload.Status == types.AlertStatusFiring {
+		if err := n.cancelTask(taskClose, owner, repo, alertID); err != nil {
+			return err
+		}
+	} else {
+		if err := n.cancelTask(taskCreate, owner, repo, alertID); err != nil {
+			return err
+		}
+	}
+	if issue == nil {
+		delayed, err := n.delayCreate(ctx, nf)
+		if err != nil || delayed {
+			return err
+		}
+	}
+	if flapping {
+		// Keep the issue open rather than closing and reopening it over and over.
+		desiredState = "open"
+		canUpdateState = true
+	}
+	var lastState *issueState
+	if issue != nil {
+		lastState = parseIssueState(issue.GetBody())
+	}
+	var reopenComment string
+	if desiredState == "open" && !flapping && isManuallyClosed(issue, lastState) {
+		var reopen bool
+		reopen, reopenComment = n.reopenManuallyClosed(ctx, nf, issue)
+		canUpdateState = reopen
+	}
+	// While acknowledged, the issue is left as humans have it, and the changes are only commented.
+	// The acknowledgment ends when the group resolves, and the issue is then updated as usual.
+	acknowledged := n.Acknowledgment.acknowledged(issue, lastState)
+	clearAcknowledgment := acknowledged && payload.Status == types.AlertStatusResolved
+	holdIssue := acknowledged && !clearAcknowledgment
+	keepBody := issue != nil && (route.commentOnChanges() || holdIssue)
+	if holdIssue {
+		canUpdateState = false
+	}
 
-	title, err := n.TitleTemplate.Execute(payload, previousIssue)
+	labels := append([]string{}, route.Labels...)
+	var removedLabels []string
+	if flapping {
+		labels = append(labels, n.Flapping.Label)
+	} else if n.Flapping != nil {
+		removedLabels = append(removedLabels, n.Flapping.Label)
+	}
+	if clearAcknowledgment && n.Acknowledgment.Label != "" {
+		removedLabels = append(removedLabels, n.Acknowledgment.Label)
+	}
+	managed, stale, err := familyLabels(route.LabelFamilies, nf.templateVars(previousIssue), issue)
+	if err != nil {
+		return err
+	}
+	labels = append(labels, managed...)
+	removedLabels = append(removedLabels, stale...)
+	fields, err := n.renderIssueFields(ctx, nf, previousIssue)
+	if err != nil {
+		return err
+	}
+	labels = append(labels, fields.labels...)
+
+	hash, err := contentHash(nf, previousIssue)
+	if err != nil {
+		return err
+	}
+	upToDateState := desiredState
+	if desiredState == "closed" && n.closePending(nf) {
+		// The issue is kept open until the delayed close, so resent resolved notifications change nothing.
+		upToDateState = "open"
+	}
+	if lastState != nil && lastState.Hash == hash && lastState.Acknowledged == acknowledged && !flappingStarted && isUpToDate(issue, labels, removedLabels, upToDateState, canUpdateState) {
+		log.Debug().Msgf("skipped an unchanged issue: %s", issue.GetURL())
+		return nil
+	}
+
+	title, err := route.TitleTemplate.ExecuteVars(nf.templateVars(previousIssue))
 	if err != nil {
 		return err
 	}
@@ -168,27 +356,97 @@ func (n *GitHubNotifier) Notify(ctx context.Context, payload *types.WebhookPaylo
 	// newlines in titles prevent Github->Slack webhooks working with issues as of 2022-05-06
 	title = strings.TrimSpace(title)
 
+	// The assignees set by the receiver, and when the acknowledgment is cleared, the human assignees it keeps.
+	assignees := ownAssignees(issue, lastState, fields.assignees)
+	if clearAcknowledgment && n.Acknowledgment.Assignees {
+		assignees = n.Acknowledgment.clearedAssignees(issue, lastState, fields.assignees)
+	}
+
+	state := newIssueState(payload, title)
+	state.Hash = hash
+	state.Acknowledged = holdIssue
+	state.Assignees = assignees
+	if holdIssue && lastState != nil {
+		// The title is not rewritten either, so it is compared with the last rendered one later.
+		state.Title = lastState.Title
+	}
+	stateMarker, err := state.marker()
+	if err != nil {
+		return err
+	}
+
+	var body string
+	if keepBody {
+		// Keep the body, and only record the new state.
+		body = replaceIssueState(issue.GetBody(), stateMarker)
+	} else {
+		content, err := route.BodyTemplate.ExecuteVars(nf.templateVars(previousIssue))
+		if err != nil {
+			return err
+		}
+		content = nf.note + content
+
+		var replaced bool
+		if issue != nil {
+			body, replaced = replaceManagedSection(issue.GetBody(), content)
+		}
+		if replaced {
+			body = replaceIssueState(body, stateMarker)
+		} else {
+			body = managedSection(content) + fmt.Sprintf(alertIDMarkerFormat, alertID) + stateMarker
+		}
+	}
+
 	req := &github.IssueRequest{
 		Title:  &title,
 		Body:   &body,
 		Labels: &labels,
 	}
+	if lastState != nil && lastState.Title != "" && issue.GetTitle() != lastState.Title {
+		// Humans have changed the title since it was last rendered.
+		req.Title = nil
+	}
+	if holdIssue {
+		req.Title = nil
+	}
+	if clearAcknowledgment && n.Acknowledgment.Assignees {
+		req.Assignees = &assignees
+	} else if len(fields.assignees) > 0 {
+		assignees := mergeAssignees(issue, fields.assignees)
+		req.Assignees = &assignees
+	}
+	req.Milestone = fields.milestone
+
+	// Nothing is compared with if the issue is about to be created or was created by an older version.
+	// The changes are commented before the new state is recorded in the body, so that they are commented again
+	// on retry if the comment fails.
+	if (route.commentOnChanges() || acknowledged) && issue != nil && lastState != nil {
+		if err := n.commentChanges(ctx, nf, issue, lastState.changes(payload)); err != nil {
+			return err
+		}
+	}
 
 	if issue == nil {
-		issue, response, err = n.GitHubClient.Issues.Create(ctx, owner, repo, req)
+		issue, err = n.createIssue(ctx, owner, repo, req)
 		if err != nil {
 			return err
 		}
 
-		updateGithubApiMetrics("issues", response)
-		log.Info().Msgf("created an issue: %s", issue.GetURL())
+		indexed = false
+		if err := n.putIndex(owner, repo, alertID, issue, previousIssue); err != nil {
+			return err
+		}
 	} else {
 		// we have to merge existing labels because Edit api replaces its  labels
 		mergedLabels := []string{}
 		labelSet := map[string]bool{}
+		removed := map[string]bool{}
+		for _, l := range removedLabels {
+			removed[l] = true
+		}
 		for _, l := range issue.Labels {
 			name := *l.Name
-			if !labelSet[name] {
+			if !labelSet[name] && !removed[name] {
 				labelSet[name] = true
 				mergedLabels = append(mergedLabels, name)
 			}
@@ -200,49 +458,129 @@ func (n *GitHubNotifier) Notify(ctx context.Context, payload *types.WebhookPaylo
 			}
 		}
 		req.Labels = &mergedLabels
-		issue, _, err = n.GitHubClient.Issues.Edit(ctx, owner, repo, issue.GetNumber(), req)
+		issue, err = n.editIssue(ctx, owner, repo, issue, req)
 		if err != nil {
 			return err
 		}
+	}
 
-		updateGithubApiMetrics("issues", response)
-		log.Info().Msgf("edited an issue: %s", issue.GetURL())
+	if desiredState != issue.GetState() && canUpdateState {
+		delayed := false
+		if desiredState == "closed" && !nf.scheduled {
+			delayed, err = n.delayClose(ctx, nf)
+			if err != nil {
+				return err
+			}
+		}
+		if !delayed {
+			req = &github.IssueRequest{
+				State: github.String(desiredState),
+			}
+			issue, err = n.editIssue(ctx, owner, repo, issue, req)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	if reopenComment != "" && !isClosed(issue) {
+		if err := n.createComment(ctx, owner, repo, issue, reopenComment); err != nil {
+			return err
+		}
+	}
+	if flappingStarted {
+		if err := n.createComment(ctx, owner, repo, issue, n.Flapping.comment()); err != nil {
+			return err
+		}
 	}
 
-	var desiredState string
+	if indexed {
+		// The index is only updated by this process, so no duplicated issues are expected.
+		return nil
+	}
+	if err := n.cleanupIssues(ctx, owner, repo, alertID, route.ReopenWindow); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// mergeAssignees returns the assignees of the issue followed by the given ones,
+// since the Edit API replaces the assignees.
+func mergeAssignees(issue *github.Issue, assignees []string) []string {
+	merged := []string{}
+	seen := map[string]bool{}
+	if issue != nil {
+		for _, u := range issue.Assignees {
+			if login := u.GetLogin(); !seen[login] {
+				seen[login] = true
+				merged = append(merged, login)
+			}
+		}
+	}
+	for _, login := range assignees {
+		if !seen[login] {
+			seen[login] = true
+			merged = append(merged, login)
+		}
+	}
+	return merged
+}
+
+func desiredIssueState(payload *types.WebhookPayload) (string, error) {
 	switch payload.Status {
 	case types.AlertStatusFiring:
-		desiredState = "open"
+		return "open", nil
 	case types.AlertStatusResolved:
-		desiredState = "closed"
+		return "closed", nil
 	default:
-		return fmt.Errorf("invalid alert status %s", payload.Status)
+		return "", fmt.Errorf("invalid alert status %s", payload.Status)
 	}
+}
 
-	currentState := issue.GetState()
-	canUpdateState := desiredState == "open" || n.shouldAutoCloseIssue(payload)
-
-	if desiredState != currentState && canUpdateState {
-		req = &github.IssueRequest{
-			State: github.String(desiredState),
+// isUpToDate returns true if notifying again would not change the state or the labels of the issue.
+func isUpToDate(issue *github.Issue, labels []string, removedLabels []string, desiredState string, canUpdateState bool) bool {
+	if issue.GetState() != desiredState && canUpdateState {
+		return false
+	}
+	current := map[string]bool{}
+	for _, l := range issue.Labels {
+		current[l.GetName()] = true
+	}
+	for _, l := range labels {
+		if !current[l] {
+			return false
 		}
-		issue, response, err = n.GitHubClient.Issues.Edit(ctx, owner, repo, issue.GetNumber(), req)
-		if err != nil {
-			return err
+	}
+	for _, l := range removedLabels {
+		if current[l] {
+			return false
 		}
+	}
+	return true
+}
 
-		updateGithubApiMetrics("issues", response)
-		log.Info().Str("state", desiredState).Msgf("updated state of the issue: %s", issue.GetURL())
+func (n *GitHubNotifier) commentChanges(ctx context.Context, nf *notification, issue *github.Issue, changes *types.AlertChanges) error {
+	if changes.Empty() {
+		return nil
+	}
+	if nf.route.CommentTemplate == nil {
+		return fmt.Errorf("comment template is not specified")
 	}
 
-	if err := n.cleanupIssues(ctx, owner, repo, alertID); err != nil {
+	vars := nf.templateVars(nil)
+	vars.Changes = changes
+	body, err := nf.route.CommentTemplate.ExecuteVars(vars)
+	if err != nil {
 		return err
 	}
-
-	return nil
+	if strings.TrimSpace(body) == "" {
+		return nil
+	}
+	return n.createComment(ctx, nf.owner, nf.repo, issue, body)
 }
 
-func (n *GitHubNotifier) cleanupIssues(ctx context.Context, owner, repo, alertID string) error {
+func (n *GitHubNotifier) cleanupIssues(ctx context.Context, owner, repo, alertID string, reopenWindow *time.Duration) error {
 	query := fmt.Sprintf(`repo:%s/%s "%s"`, owner, repo, alertID)
 	searchResult, response, err := n.GitHubClient.Search.Issues(ctx, query, &github.SearchOptions{
 		TextMatch: true,
@@ -270,7 +608,7 @@ func (n *GitHubNotifier) cleanupIssues(ctx context.Context, owner, repo, alertID
 	latestIssue := issues[len(issues)-1]
 	oldIssues := issues[:len(issues)-1]
 	for _, issue := range oldIssues {
-		if n.ReopenWindow != nil && isClosed(issue) {
+		if reopenWindow != nil && isClosed(issue) {
 			// If the reopen window is set, multiple closed issues are expected.
 			// Keep them untouched.
 			continue
@@ -279,20 +617,16 @@ func (n *GitHubNotifier) cleanupIssues(ctx context.Context, owner, repo, alertID
 			Body:  github.String(fmt.Sprintf("duplicated %s", latestIssue.GetHTMLURL())),
 			State: github.String("closed"),
 		}
-		issue, response, err = n.GitHubClient.Issues.Edit(ctx, owner, repo, issue.GetNumber(), req)
-		if err != nil {
+		if _, err := n.editIssue(ctx, owner, repo, issue, req); err != nil {
 			return err
 		}
-
-		updateGithubApiMetrics("issues", response)
-		log.Info().Msgf("closed an issue: %s", issue.GetURL())
 	}
 
 	return nil
 }
 
-func (n *GitHubNotifier) getAlertID(payload *types.WebhookPayload) (string, error) {
-	id, err := n.AlertIDTemplate.Execute(payload, nil)
+func getAlertID(route *Route, payload *types.WebhookPayload) (string, error) {
+	id, err := route.AlertIDTemplate.Execute(payload, nil)
 	if err != nil {
 		return "", err
 	}
@@ -300,8 +634,20 @@ func (n *GitHubNotifier) getAlertID(payload *types.WebhookPayload) (string, erro
 	return fmt.Sprintf("%x", sha256.Sum256([]byte(id))), nil
 }
 
-func (n *GitHubNotifier) shouldAutoCloseIssue(payload *types.WebhookPayload) bool {
-	if !n.AutoCloseResolvedIssues {
+func coalesceKey(payload *types.WebhookPayload, queryParams url.Values) (string, error) {
+	b, err := json.Marshal(struct {
+		Payload     *types.WebhookPayload `json:"payload"`
+		QueryParams url.Values            `json:"queryParams"`
+	}{payload, queryParams})
+	if err != nil {
+		return "", err
+	}
+
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+func shouldAutoCloseIssue(route *Route, payload *types.WebhookPayload) bool {
+	if !route.autoCloseResolvedIssues() {
 		return false
 	}
 
@@ -315,6 +661,11 @@ func checkSearchResponse(response *github.Response) error {
 	return nil
 }
 
+// UpdateGithubApiMetrics records the rate limit and the status of a GitHub API response made outside the notifier.
+func UpdateGithubApiMetrics(apiName string, resp *github.Response) {
+	updateGithubApiMetrics(apiName, resp)
+}
+
 func updateGithubApiMetrics(apiName string, resp *github.Response) {
 	rateLimit.WithLabelValues(apiName).Set(float64(resp.Rate.Limit))
 	rateRemaining.WithLabelValues(apiName).Set(float64(resp.Rate.Remaining))
@@ -0,0 +1,941 @@
+package notifier
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/index"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/matcher"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/scheduler"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/template"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeGitHub is a minimal in-memory implementation of the GitHub issues API.
+type fakeGitHub struct {
+	mu                 sync.Mutex
+	issues             []*github.Issue
+	comments           map[int][]string
+	requests           []string
+	rateLimitRemaining int
+	// If true, creating comments fails.
+	failComments bool
+	// Repository metadata, which is the same for every repository.
+	labels     []string
+	milestones map[string]int
+	assignees  map[string]bool
+}
+
+func newFakeGitHub(t *testing.T) (*fakeGitHub, *github.Client) {
+	f := &fakeGitHub{rateLimitRemaining: 5000, comments: map[int][]string{}}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /search/issues", f.searchIssues)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/issues", f.listIssues)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/issues", f.createIssue)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/issues/{number}", f.getIssue)
+	mux.HandleFunc("PATCH /repos/{owner}/{repo}/issues/{number}", f.editIssue)
+	mux.HandleFunc("POST /repos/{owner}/{repo}/issues/{number}/comments", f.createComment)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/labels", f.listLabels)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/milestones", f.listMilestones)
+	mux.HandleFunc("GET /repos/{owner}/{repo}/assignees/{login}", f.checkAssignee)
+	mux.HandleFunc("GET /rate_limit", f.rateLimits)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.mu.Lock()
+		f.requests = append(f.requests, r.Method+" "+r.URL.Path)
+		f.mu.Unlock()
+		mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+
+	client := github.NewClient(nil)
+	baseURL, err := url.Parse(srv.URL + "/")
+	require.NoError(t, err)
+	client.BaseURL = baseURL
+
+	return f, client
+}
+
+func (f *fakeGitHub) countRequests(prefix string) int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	count := 0
+	for _, r := range f.requests {
+		if strings.HasPrefix(r, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+func (f *fakeGitHub) findIssue(r *http.Request) *github.Issue {
+	number, err := strconv.Atoi(r.PathValue("number"))
+	if err != nil {
+		return nil
+	}
+	for _, issue := range f.issues {
+		if issue.GetNumber() == number && issue.GetRepository().GetFullName() == r.PathValue("owner")+"/"+r.PathValue("repo") {
+			return issue
+		}
+	}
+	return nil
+}
+
+var searchQueryRegexp = regexp.MustCompile(`^repo:(\S+) "(.*)"$`)
+
+func (f *fakeGitHub) searchIssues(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	m := searchQueryRegexp.FindStringSubmatch(r.URL.Query().Get("q"))
+	result := &github.IssuesSearchResult{Issues: []*github.Issue{}}
+	for _, issue := range f.issues {
+		if m != nil && issue.GetRepository().GetFullName() == m[1] && strings.Contains(issue.GetBody(), m[2]) {
+			result.Issues = append(result.Issues, issue)
+		}
+	}
+	result.Total = github.Int(len(result.Issues))
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (f *fakeGitHub) listIssues(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	issues := []*github.Issue{}
+	for _, issue := range f.issues {
+		if issue.GetRepository().GetFullName() == r.PathValue("owner")+"/"+r.PathValue("repo") {
+			issues = append(issues, issue)
+		}
+	}
+	writeJSON(w, http.StatusOK, issues)
+}
+
+func (f *fakeGitHub) createIssue(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	req := &github.IssueRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, nil)
+		return
+	}
+
+	number := len(f.issues) + 1
+	issue := &github.Issue{
+		Number:    github.Int(number),
+		State:     github.String("open"),
+		Title:     req.Title,
+		Body:      req.Body,
+		CreatedAt: &github.Timestamp{Time: time.Now().Add(time.Duration(number) * time.Second)},
+		HTMLURL:   github.String("https://github.example.com/" + r.PathValue("owner") + "/" + r.PathValue("repo") + "/issues/" + strconv.Itoa(number)),
+		Repository: &github.Repository{
+			FullName: github.String(r.PathValue("owner") + "/" + r.PathValue("repo")),
+		},
+	}
+	setLabels(issue, req.Labels)
+	setAssignees(issue, req.Assignees)
+	setMilestone(issue, req.Milestone)
+	f.issues = append(f.issues, issue)
+	writeJSON(w, http.StatusCreated, issue)
+}
+
+func (f *fakeGitHub) getIssue(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	issue := f.findIssue(r)
+	if issue == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+	writeJSON(w, http.StatusOK, issue)
+}
+
+func (f *fakeGitHub) editIssue(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	issue := f.findIssue(r)
+	if issue == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+
+	req := &github.IssueRequest{}
+	if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+		writeJSON(w, http.StatusBadRequest, nil)
+		return
+	}
+	if req.Title != nil {
+		issue.Title = req.Title
+	}
+	if req.Body != nil {
+		issue.Body = req.Body
+	}
+	if req.State != nil && *req.State != issue.GetState() {
+		issue.State = req.State
+		if *req.State == "closed" {
+			issue.ClosedAt = &github.Timestamp{Time: time.Now()}
+		} else {
+			issue.ClosedAt = nil
+		}
+	}
+	setLabels(issue, req.Labels)
+	setAssignees(issue, req.Assignees)
+	setMilestone(issue, req.Milestone)
+	writeJSON(w, http.StatusOK, issue)
+}
+
+func (f *fakeGitHub) createComment(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	issue := f.findIssue(r)
+	if issue == nil {
+		writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+		return
+	}
+	if f.failComments {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"message": "Internal Server Error"})
+		return
+	}
+
+	comment := &github.IssueComment{}
+	if err := json.NewDecoder(r.Body).Decode(comment); err != nil {
+		writeJSON(w, http.StatusBadRequest, nil)
+		return
+	}
+	f.comments[issue.GetNumber()] = append(f.comments[issue.GetNumber()], comment.GetBody())
+	writeJSON(w, http.StatusCreated, comment)
+}
+
+func (f *fakeGitHub) rateLimits(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"resources": &github.RateLimits{
+			Core: &github.Rate{Limit: 5000, Remaining: f.rateLimitRemaining, Reset: github.Timestamp{Time: time.Now().Add(time.Hour)}},
+		},
+	})
+}
+
+func setLabels(issue *github.Issue, labels *[]string) {
+	if labels == nil {
+		return
+	}
+	issue.Labels = nil
+	for _, l := range *labels {
+		issue.Labels = append(issue.Labels, &github.Label{Name: github.String(l)})
+	}
+}
+
+func setAssignees(issue *github.Issue, assignees *[]string) {
+	if assignees == nil {
+		return
+	}
+	issue.Assignees = nil
+	for _, login := range *assignees {
+		issue.Assignees = append(issue.Assignees, &github.User{Login: github.String(login)})
+	}
+}
+
+func setMilestone(issue *github.Issue, number *int) {
+	if number != nil {
+		issue.Milestone = &github.Milestone{Number: number}
+	}
+}
+
+func (f *fakeGitHub) listLabels(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	labels := []*github.Label{}
+	for _, l := range f.labels {
+		labels = append(labels, &github.Label{Name: github.String(l)})
+	}
+	writeJSON(w, http.StatusOK, labels)
+}
+
+func (f *fakeGitHub) listMilestones(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	milestones := []*github.Milestone{}
+	for title, number := range f.milestones {
+		milestones = append(milestones, &github.Milestone{Title: github.String(title), Number: github.Int(number)})
+	}
+	writeJSON(w, http.StatusOK, milestones)
+}
+
+func (f *fakeGitHub) checkAssignee(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.assignees[r.PathValue("login")] {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusNotFound, map[string]string{"message": "Not Found"})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func mustParseTemplate(t *testing.T, s string) *template.Template {
+	tmpl, err := template.Parse(s)
+	require.NoError(t, err)
+	return tmpl
+}
+
+func mustParseMatchers(t *testing.T, ss ...string) matcher.Matchers {
+	ms, err := matcher.ParseAll(ss)
+	require.NoError(t, err)
+	return ms
+}
+
+func newTestNotifier(t *testing.T) (*GitHubNotifier, *fakeGitHub) {
+	f, client := newFakeGitHub(t)
+
+	n, err := NewGitHub()
+	require.NoError(t, err)
+	n.GitHubClient = client
+	n.SetRoute(&Route{
+		BodyTemplate:            mustParseTemplate(t, "{{.Payload.Status}}"),
+		TitleTemplate:           mustParseTemplate(t, "[ALERT] {{.Payload.GroupKey}}"),
+		AlertIDTemplate:         mustParseTemplate(t, "{{.Payload.GroupKey}}"),
+		Labels:                  []string{},
+		AutoCloseResolvedIssues: github.Bool(true),
+	})
+
+	return n, f
+}
+
+func testPayload(status types.AlertStatus) *types.WebhookPayload {
+	return &types.WebhookPayload{
+		GroupKey:     "group1",
+		Status:       status,
+		CommonLabels: map[string]string{"alertname": "Test"},
+		Alerts: []types.WebhookAlert{{
+			Status: status,
+			Labels: map[string]string{"alertname": "Test"},
+		}},
+	}
+}
+
+var testParams = url.Values{"owner": {"foo"}, "repo": {"bar"}}
+
+func TestNotifyCreatesAndClosesIssue(t *testing.T) {
+	n, f := newTestNotifier(t)
+	ctx := context.Background()
+
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+	require.Len(t, f.issues, 1)
+	assert.Equal(t, "open", f.issues[0].GetState())
+	assert.Equal(t, "[ALERT] group1", f.issues[0].GetTitle())
+
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusResolved), testParams))
+	require.Len(t, f.issues, 1)
+	assert.Equal(t, "closed", f.issues[0].GetState())
+
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+	require.Len(t, f.issues, 1)
+	assert.Equal(t, "open", f.issues[0].GetState())
+}
+
+func TestNotifyUsesIndex(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.Index = index.NewMemory()
+	ctx := context.Background()
+
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+	require.Len(t, f.issues, 1)
+	searches := f.countRequests("GET /search/issues")
+
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusResolved), testParams))
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+	assert.Len(t, f.issues, 1)
+	assert.Equal(t, searches, f.countRequests("GET /search/issues"))
+	assert.Equal(t, "open", f.issues[0].GetState())
+}
+
+func TestBootstrapIndex(t *testing.T) {
+	n, f := newTestNotifier(t)
+	ctx := context.Background()
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+
+	n.Index = index.NewMemory()
+	require.NoError(t, n.BootstrapIndex(ctx, "foo", "bar"))
+
+	alertID, err := getAlertID(n.Route(), testPayload(types.AlertStatusFiring))
+	require.NoError(t, err)
+	entry, ok, err := n.Index.Get("foo", "bar", alertID)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, f.issues[0].GetNumber(), entry.Number)
+}
+
+func TestNotifyRepositoryPolicy(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.RepositoryPolicy = &RepositoryPolicy{Allow: []string{"ops"}}
+	ctx := context.Background()
+
+	// Retrying a payload rejected by the policy cannot succeed.
+	err := n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams)
+	assert.Error(t, err)
+	assert.True(t, IsPermanent(err))
+	assert.Empty(t, f.issues)
+
+	n.RepositoryPolicy.FallbackOwner = "ops"
+	n.RepositoryPolicy.FallbackRepo = "alerts"
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+	require.Len(t, f.issues, 1)
+	assert.Equal(t, "ops/alerts", f.issues[0].GetRepository().GetFullName())
+	assert.Contains(t, f.issues[0].GetBody(), "`foo/bar`")
+}
+
+func TestNotifyRoutes(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.Route().Routes = []*Route{
+		{
+			Matchers: mustParseMatchers(t, `team="db"`),
+			Repo:     "db-alerts",
+			Labels:   []string{"db"},
+			Routes: []*Route{{
+				Matchers:      mustParseMatchers(t, `severity="critical"`),
+				TitleTemplate: mustParseTemplate(t, "[CRITICAL] {{.Payload.GroupKey}}"),
+			}},
+		},
+	}
+	ctx := context.Background()
+
+	payload := testPayload(types.AlertStatusFiring)
+	payload.CommonLabels["team"] = "db"
+	payload.CommonLabels["severity"] = "critical"
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	require.Len(t, f.issues, 1)
+	assert.Equal(t, "foo/db-alerts", f.issues[0].GetRepository().GetFullName())
+	assert.Equal(t, "[CRITICAL] group1", f.issues[0].GetTitle())
+	require.Len(t, f.issues[0].Labels, 1)
+	assert.Equal(t, "db", f.issues[0].Labels[0].GetName())
+
+	payload = testPayload(types.AlertStatusFiring)
+	payload.GroupKey = "group2"
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	require.Len(t, f.issues, 2)
+	assert.Equal(t, "foo/bar", f.issues[1].GetRepository().GetFullName())
+	assert.Equal(t, "[ALERT] group2", f.issues[1].GetTitle())
+}
+
+func TestNotifyIssuePerAlert(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.Route().IssuePerAlert = github.Bool(true)
+	n.Route().TitleTemplate = mustParseTemplate(t, "[ALERT] {{.Alert.Labels.instance}} {{.Payload.CommonLabels.instance}}")
+	ctx := context.Background()
+
+	payload := testPayload(types.AlertStatusFiring)
+	payload.Alerts = []types.WebhookAlert{
+		{Status: types.AlertStatusFiring, Labels: map[string]string{"alertname": "Test", "instance": "a"}},
+		{Status: types.AlertStatusResolved, Labels: map[string]string{"alertname": "Test", "instance": "b"}},
+	}
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	require.Len(t, f.issues, 2)
+	assert.Equal(t, "[ALERT] a a", f.issues[0].GetTitle())
+	assert.Equal(t, "open", f.issues[0].GetState())
+	assert.Equal(t, "[ALERT] b b", f.issues[1].GetTitle())
+	assert.Equal(t, "closed", f.issues[1].GetState())
+
+	// Each alert is tracked independently of the group.
+	payload.Alerts[0].Status = types.AlertStatusResolved
+	payload.Alerts[1].Status = types.AlertStatusFiring
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	require.Len(t, f.issues, 2)
+	assert.Equal(t, "closed", f.issues[0].GetState())
+	assert.Equal(t, "open", f.issues[1].GetState())
+}
+
+func TestNotifySplitsByRepository(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.Route().TitleTemplate = mustParseTemplate(t, "[ALERT] {{.Payload.CommonLabels.atg_repo}}")
+	ctx := context.Background()
+
+	payload := testPayload(types.AlertStatusFiring)
+	payload.Alerts = []types.WebhookAlert{
+		{Status: types.AlertStatusFiring, Labels: map[string]string{"alertname": "Test", "atg_repo": "repo1"}},
+		{Status: types.AlertStatusResolved, Labels: map[string]string{"alertname": "Test", "atg_repo": "repo2"}},
+		{Status: types.AlertStatusFiring, Labels: map[string]string{"alertname": "Test", "atg_repo": "repo1"}},
+	}
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	require.Len(t, f.issues, 2)
+	assert.Equal(t, "foo/repo1", f.issues[0].GetRepository().GetFullName())
+	assert.Equal(t, "[ALERT] repo1", f.issues[0].GetTitle())
+	assert.Equal(t, "open", f.issues[0].GetState())
+	assert.Equal(t, "foo/repo2", f.issues[1].GetRepository().GetFullName())
+	assert.Equal(t, "[ALERT] repo2", f.issues[1].GetTitle())
+	assert.Equal(t, "closed", f.issues[1].GetState())
+}
+
+func TestNotifyCommentOnChanges(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.Route().CommentOnChanges = github.Bool(true)
+	n.Route().BodyTemplate = mustParseTemplate(t, "{{len .Payload.Alerts}} alerts")
+	n.Route().CommentTemplate = mustParseTemplate(t, "added={{len .Changes.Added}} resolved={{len .Changes.Resolved}} groupResolved={{.Changes.GroupResolved}} groupRefired={{.Changes.GroupRefired}}")
+	ctx := context.Background()
+
+	alertA := types.WebhookAlert{Status: types.AlertStatusFiring, Labels: map[string]string{"alertname": "Test", "instance": "a"}}
+	alertB := types.WebhookAlert{Status: types.AlertStatusFiring, Labels: map[string]string{"alertname": "Test", "instance": "b"}}
+	payload := testPayload(types.AlertStatusFiring)
+	payload.Alerts = []types.WebhookAlert{alertA}
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	require.Len(t, f.issues, 1)
+	assert.Empty(t, f.comments[1])
+
+	// Nothing has changed.
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	assert.Empty(t, f.comments[1])
+
+	// The changes are commented on retry if the comment fails.
+	payload.Alerts = []types.WebhookAlert{alertA, alertB}
+	f.mu.Lock()
+	f.failComments = true
+	f.mu.Unlock()
+	assert.Error(t, n.Notify(ctx, payload, testParams))
+	assert.Empty(t, f.comments[1])
+	f.mu.Lock()
+	f.failComments = false
+	f.mu.Unlock()
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	require.Len(t, f.comments[1], 1)
+	assert.Equal(t, "added=1 resolved=0 groupResolved=false groupRefired=false", f.comments[1][0])
+
+	resolved := testPayload(types.AlertStatusResolved)
+	resolved.Alerts = []types.WebhookAlert{alertA, alertB}
+	for i := range resolved.Alerts {
+		resolved.Alerts[i].Status = types.AlertStatusResolved
+	}
+	require.NoError(t, n.Notify(ctx, resolved, testParams))
+	require.Len(t, f.comments[1], 2)
+	assert.Equal(t, "added=0 resolved=2 groupResolved=true groupRefired=false", f.comments[1][1])
+	assert.Equal(t, "closed", f.issues[0].GetState())
+
+	payload.Alerts = []types.WebhookAlert{alertA}
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	require.Len(t, f.comments[1], 3)
+	assert.Equal(t, "added=1 resolved=0 groupResolved=false groupRefired=true", f.comments[1][2])
+	assert.Equal(t, "open", f.issues[0].GetState())
+
+	// The body is rendered only when the issue is created.
+	assert.True(t, strings.HasPrefix(f.issues[0].GetBody(), managedSection("1 alerts")))
+	assert.Len(t, f.issues, 1)
+}
+
+func TestNotifyPreservesHumanEdits(t *testing.T) {
+	n, f := newTestNotifier(t)
+	ctx := context.Background()
+
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+	require.Len(t, f.issues, 1)
+
+	f.issues[0].Title = github.String("DB is down")
+	f.issues[0].Body = github.String("Notes before\n" + f.issues[0].GetBody() + "Notes after\n")
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusResolved), testParams))
+	assert.Equal(t, "DB is down", f.issues[0].GetTitle())
+	body := f.issues[0].GetBody()
+	assert.True(t, strings.HasPrefix(body, "Notes before\n"+managedSection("resolved")))
+	assert.True(t, strings.HasSuffix(body, "Notes after\n"))
+
+	// Issues created by older versions are replaced as a whole.
+	f.issues[0].Title = github.String("[ALERT] group1")
+	alertID, err := getAlertID(n.Route(), testPayload(types.AlertStatusFiring))
+	require.NoError(t, err)
+	f.issues[0].Body = github.String("firing" + fmt.Sprintf(alertIDMarkerFormat, alertID))
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+	assert.Equal(t, "[ALERT] group1", f.issues[0].GetTitle())
+	assert.True(t, strings.HasPrefix(f.issues[0].GetBody(), managedSection("firing")))
+	assert.Len(t, f.issues, 1)
+}
+
+func TestNotifySkipsUnchangedIssue(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.Route().BodyTemplate = mustParseTemplate(t, "{{.Payload.Status}} {{timeNow}}")
+	ctx := context.Background()
+
+	payload := testPayload(types.AlertStatusFiring)
+	payload.Alerts[0].EndsAt = time.Now()
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	require.Len(t, f.issues, 1)
+	patches := f.countRequests("PATCH")
+	searches := f.countRequests("GET /search/issues")
+
+	// Alertmanager pushes EndsAt of firing alerts forward on every resend.
+	payload.Alerts[0].EndsAt = time.Now().Add(time.Minute)
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	assert.Equal(t, patches, f.countRequests("PATCH"))
+	// The issue is searched, but duplicates are not.
+	assert.Equal(t, searches+1, f.countRequests("GET /search/issues"))
+
+	// The issue is edited if it is not as desired even though the alerts are unchanged.
+	f.issues[0].State = github.String("closed")
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	assert.Equal(t, "open", f.issues[0].GetState())
+	patches = f.countRequests("PATCH")
+
+	payload.Alerts[0].Annotations = map[string]string{"summary": "changed"}
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	assert.Less(t, patches, f.countRequests("PATCH"))
+}
+
+func TestNotifyFlapping(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.Flapping = &FlappingDetector{Window: time.Hour, Threshold: 3, StablePeriod: time.Hour, Label: "flapping"}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, status := range []types.AlertStatus{types.AlertStatusFiring, types.AlertStatusResolved, types.AlertStatusFiring} {
+		require.NoError(t, n.Notify(ctx, testPayload(status), testParams))
+	}
+	require.Len(t, f.issues, 1)
+	assert.Equal(t, "open", f.issues[0].GetState())
+	assert.Empty(t, f.comments[1])
+
+	for _, status := range []types.AlertStatus{types.AlertStatusResolved, types.AlertStatusFiring, types.AlertStatusResolved} {
+		require.NoError(t, n.Notify(ctx, testPayload(status), testParams))
+		assert.Equal(t, "open", f.issues[0].GetState())
+		assert.Equal(t, []string{"flapping"}, issueLabels(f.issues[0]))
+	}
+	assert.Len(t, f.comments[1], 1)
+
+	// The issue is closed once the alert is stable, even though Alertmanager does not notify again.
+	n.Flapping.StablePeriod = 0
+	go n.RunFlappingDetector(ctx, 10*time.Millisecond)
+	assert.Eventually(t, func() bool {
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		return f.issues[0].GetState() == "closed" && len(f.issues[0].Labels) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestNotifyAcknowledged(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.Acknowledgment = &Acknowledgment{Label: "acknowledged", Assignees: true}
+	n.Route().BodyTemplate = mustParseTemplate(t, "{{len .Payload.Alerts}} alerts")
+	n.Route().CommentTemplate = mustParseTemplate(t, "added={{len .Changes.Added}} groupResolved={{.Changes.GroupResolved}}")
+	ctx := context.Background()
+
+	alertA := types.WebhookAlert{Status: types.AlertStatusFiring, Labels: map[string]string{"alertname": "Test", "instance": "a"}}
+	alertB := types.WebhookAlert{Status: types.AlertStatusFiring, Labels: map[string]string{"alertname": "Test", "instance": "b"}}
+	payload := testPayload(types.AlertStatusFiring)
+	payload.Alerts = []types.WebhookAlert{alertA}
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	require.Len(t, f.issues, 1)
+
+	f.issues[0].Labels = []*github.Label{{Name: github.String("acknowledged")}}
+	f.issues[0].Assignees = []*github.User{{Login: github.String("alice")}}
+	payload.Alerts = []types.WebhookAlert{alertA, alertB}
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	assert.True(t, strings.HasPrefix(f.issues[0].GetBody(), managedSection("1 alerts")))
+	assert.Equal(t, []string{"added=1 groupResolved=false"}, f.comments[1])
+
+	// Acknowledged issues are not reopened.
+	f.issues[0].State = github.String("closed")
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	assert.Equal(t, "closed", f.issues[0].GetState())
+	f.issues[0].State = github.String("open")
+
+	resolved := testPayload(types.AlertStatusResolved)
+	resolved.Alerts = []types.WebhookAlert{alertA, alertB}
+	for i := range resolved.Alerts {
+		resolved.Alerts[i].Status = types.AlertStatusResolved
+	}
+	require.NoError(t, n.Notify(ctx, resolved, testParams))
+	assert.Equal(t, []string{"added=1 groupResolved=false", "added=0 groupResolved=true"}, f.comments[1])
+	assert.Equal(t, "closed", f.issues[0].GetState())
+	assert.Empty(t, issueLabels(f.issues[0]))
+	// The issue was acknowledged with the label, so the human assignees are left alone.
+	require.Len(t, f.issues[0].Assignees, 1)
+	assert.Equal(t, "alice", f.issues[0].Assignees[0].GetLogin())
+	assert.True(t, strings.HasPrefix(f.issues[0].GetBody(), managedSection("2 alerts")))
+
+	// The next firing is not acknowledged.
+	payload.Alerts = []types.WebhookAlert{alertA}
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	assert.Equal(t, "open", f.issues[0].GetState())
+	assert.True(t, strings.HasPrefix(f.issues[0].GetBody(), managedSection("1 alerts")))
+	assert.Len(t, f.comments[1], 2)
+
+	// Only the assignees who acknowledged the issue are removed when it resolves.
+	f.issues[0].Assignees = append(f.issues[0].Assignees, &github.User{Login: github.String("bob")})
+	payload.Alerts = []types.WebhookAlert{alertA, alertB}
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	assert.True(t, strings.HasPrefix(f.issues[0].GetBody(), managedSection("1 alerts")))
+	require.NoError(t, n.Notify(ctx, resolved, testParams))
+	assert.Equal(t, "closed", f.issues[0].GetState())
+	require.Len(t, f.issues[0].Assignees, 1)
+	assert.Equal(t, "alice", f.issues[0].Assignees[0].GetLogin())
+}
+
+func TestNotifyManuallyClosed(t *testing.T) {
+	hour := time.Hour
+	tests := []struct {
+		name     string
+		policy   ManualClosePolicy
+		closedAt time.Time
+		reopened bool
+	}{
+		{name: "default", policy: "", closedAt: time.Now(), reopened: true},
+		{name: "respect until resolved", policy: ManualCloseRespectUntilResolved, closedAt: time.Now().Add(-2 * hour), reopened: false},
+		{name: "respect for duration", policy: ManualCloseRespectForDuration, closedAt: time.Now(), reopened: false},
+		{name: "respect for elapsed duration", policy: ManualCloseRespectForDuration, closedAt: time.Now().Add(-2 * hour), reopened: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			n, f := newTestNotifier(t)
+			n.Route().ManualClosePolicy = tt.policy
+			n.Route().ManualCloseDuration = &hour
+			ctx := context.Background()
+
+			require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+			require.Len(t, f.issues, 1)
+			f.issues[0].State = github.String("closed")
+			f.issues[0].ClosedAt = &github.Timestamp{Time: tt.closedAt}
+			f.issues[0].ClosedBy = &github.User{Login: github.String("alice")}
+
+			require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+			if !tt.reopened {
+				assert.Equal(t, "closed", f.issues[0].GetState())
+				assert.Empty(t, f.comments[1])
+
+				if tt.policy == ManualCloseRespectUntilResolved {
+					// The issue is reopened once the alerts resolve and fire again.
+					require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusResolved), testParams))
+					require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+					assert.Equal(t, "open", f.issues[0].GetState())
+					assert.Empty(t, f.comments[1])
+				}
+				return
+			}
+			assert.Equal(t, "open", f.issues[0].GetState())
+			require.Len(t, f.comments[1], 1)
+			assert.Contains(t, f.comments[1][0], "closed by @alice")
+
+			// Issues closed by the receiver are reopened without comments.
+			require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusResolved), testParams))
+			require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+			assert.Equal(t, "open", f.issues[0].GetState())
+			assert.Len(t, f.comments[1], 1)
+		})
+	}
+}
+
+func TestNotifyLabelFamilies(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.Route().Labels = []string{"alert"}
+	n.Route().LabelFamilies = []*LabelFamily{
+		{Prefix: "status/", Template: mustParseTemplate(t, "status/{{.Payload.Status}}")},
+		{Labels: []string{"critical", "warning"}, Template: mustParseTemplate(t, `{{index .Payload.CommonLabels "severity"}}`)},
+		{Prefix: "team/", Template: mustParseTemplate(t, `team/{{index .Payload.CommonLabels "team"}}`)},
+	}
+	ctx := context.Background()
+
+	payload := testPayload(types.AlertStatusFiring)
+	payload.CommonLabels["severity"] = "critical"
+	payload.CommonLabels["team"] = "db"
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	require.Len(t, f.issues, 1)
+	assert.ElementsMatch(t, []string{"alert", "status/firing", "critical", "team/db"}, issueLabels(f.issues[0]))
+
+	f.issues[0].Labels = append(f.issues[0].Labels, &github.Label{Name: github.String("triaged")})
+	// The team family renders only its prefix "team/" without the team label, which clears the family.
+	payload = testPayload(types.AlertStatusResolved)
+	payload.CommonLabels["severity"] = "warning"
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	assert.ElementsMatch(t, []string{"alert", "triaged", "status/resolved", "warning"}, issueLabels(f.issues[0]))
+
+	// The family is cleared if the template renders nothing.
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+	assert.ElementsMatch(t, []string{"alert", "triaged", "status/firing"}, issueLabels(f.issues[0]))
+
+	// Labels outside the family are skipped rather than accumulated, and do not fail the notification.
+	payload = testPayload(types.AlertStatusResolved)
+	payload.CommonLabels["severity"] = "info"
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	assert.ElementsMatch(t, []string{"alert", "triaged", "status/resolved"}, issueLabels(f.issues[0]))
+}
+
+func TestNotifyTemplatedFields(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.MetadataCacheTTL = time.Hour
+	n.Acknowledgment = &Acknowledgment{Assignees: true}
+	f.labels = []string{"Team/DB", "team/web"}
+	f.milestones = map[string]int{"2026Q4": 7}
+	f.assignees = map[string]bool{"alice": true}
+	n.Route().LabelsTemplate = mustParseTemplate(t, `{{range .Payload.Alerts}}team/{{index .Labels "team"}},{{end}}`)
+	n.Route().AssigneesTemplate = mustParseTemplate(t, `{{index .Payload.CommonAnnotations "owner"}}`)
+	n.Route().MilestoneTemplate = mustParseTemplate(t, `{{index .Payload.CommonLabels "milestone"}}`)
+	ctx := context.Background()
+
+	payload := testPayload(types.AlertStatusFiring)
+	payload.Alerts = []types.WebhookAlert{
+		{Status: types.AlertStatusFiring, Labels: map[string]string{"alertname": "Test", "team": "db"}},
+		{Status: types.AlertStatusFiring, Labels: map[string]string{"alertname": "Test", "team": "storage"}},
+	}
+	payload.CommonAnnotations = map[string]string{"owner": "@alice, bob"}
+	payload.CommonLabels["milestone"] = "2026Q4"
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	require.Len(t, f.issues, 1)
+	assert.Equal(t, []string{"team/db"}, issueLabels(f.issues[0]))
+	require.Len(t, f.issues[0].Assignees, 1)
+	assert.Equal(t, "alice", f.issues[0].Assignees[0].GetLogin())
+	assert.Equal(t, 7, f.issues[0].GetMilestone().GetNumber())
+
+	// The assignees set by the receiver do not acknowledge the issue.
+	payload.CommonLabels["milestone"] = "unknown"
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	assert.Equal(t, 7, f.issues[0].GetMilestone().GetNumber())
+	assert.True(t, strings.HasPrefix(f.issues[0].GetBody(), managedSection("firing")))
+
+	// The repository metadata is cached.
+	assert.Equal(t, 1, f.countRequests("GET /repos/foo/bar/labels"))
+	assert.Equal(t, 1, f.countRequests("GET /repos/foo/bar/milestones"))
+	assert.Equal(t, 1, f.countRequests("GET /repos/foo/bar/assignees/alice"))
+}
+
+func TestNotifyDelaysClose(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.Scheduler = scheduler.NewMemory()
+	delay := time.Hour
+	n.Route().AutoCloseDelay = &delay
+	ctx := context.Background()
+
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusResolved), testParams))
+	require.Len(t, f.issues, 1)
+	assert.Equal(t, "open", f.issues[0].GetState())
+	assert.Equal(t, 1, n.Scheduler.Len())
+
+	// Firing again cancels the close.
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+	assert.Equal(t, "open", f.issues[0].GetState())
+	assert.Equal(t, 0, n.Scheduler.Len())
+
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusResolved), testParams))
+	// Resent resolved notifications do not edit the issue while the close is pending.
+	patches := f.countRequests("PATCH")
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusResolved), testParams))
+	assert.Equal(t, patches, f.countRequests("PATCH"))
+	alertID, err := getAlertID(n.Route(), testPayload(types.AlertStatusResolved))
+	require.NoError(t, err)
+	task, ok := n.Scheduler.Get(taskKey(taskClose, "foo", "bar", alertID))
+	require.True(t, ok)
+	assert.WithinDuration(t, time.Now().Add(delay), task.At, time.Minute)
+
+	require.NoError(t, n.RunTask(ctx, task))
+	assert.Equal(t, "closed", f.issues[0].GetState())
+
+	// The annotation overrides the route.
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+	payload := testPayload(types.AlertStatusResolved)
+	payload.Alerts[0].Annotations = map[string]string{"atg_auto_close_delay": "0s"}
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	assert.Equal(t, "closed", f.issues[0].GetState())
+	assert.Equal(t, 0, n.Scheduler.Len())
+}
+
+func TestRunTaskResolvesRouteOfGroup(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.Scheduler = scheduler.NewMemory()
+	delay := time.Hour
+	n.Route().AutoCloseDelay = &delay
+	n.Route().Routes = []*Route{{
+		Matchers:      mustParseMatchers(t, `severity="critical"`),
+		TitleTemplate: mustParseTemplate(t, "[CRITICAL] {{.Payload.GroupKey}}"),
+	}}
+	ctx := context.Background()
+
+	// The group is routed by its common labels, although the alerts in repo1 are all critical.
+	payload := func(status types.AlertStatus) *types.WebhookPayload {
+		p := testPayload(status)
+		p.Alerts = []types.WebhookAlert{
+			{Status: status, Labels: map[string]string{"alertname": "Test", "severity": "critical", "atg_repo": "repo1"}},
+			{Status: status, Labels: map[string]string{"alertname": "Test", "severity": "warning", "atg_repo": "repo2"}},
+		}
+		return p
+	}
+	require.NoError(t, n.Notify(ctx, payload(types.AlertStatusFiring), testParams))
+	require.NoError(t, n.Notify(ctx, payload(types.AlertStatusResolved), testParams))
+	require.Len(t, f.issues, 2)
+	assert.Equal(t, "[ALERT] group1", f.issues[0].GetTitle())
+
+	alertID, err := getAlertID(n.Route(), payload(types.AlertStatusResolved))
+	require.NoError(t, err)
+	task, ok := n.Scheduler.Get(taskKey(taskClose, "foo", "repo1", alertID))
+	require.True(t, ok)
+	require.NoError(t, n.RunTask(ctx, task))
+	assert.Equal(t, "closed", f.issues[0].GetState())
+	assert.Equal(t, "[ALERT] group1", f.issues[0].GetTitle())
+	assert.Equal(t, "open", f.issues[1].GetState())
+}
+
+func TestNotifyMinFiringDuration(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.Scheduler = scheduler.NewMemory()
+	minFiring := 10 * time.Minute
+	n.Route().MinFiringDuration = &minFiring
+	ctx := context.Background()
+
+	startsAt := time.Now().Add(-time.Minute)
+	payload := testPayload(types.AlertStatusFiring)
+	payload.Alerts[0].StartsAt = startsAt
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	assert.Empty(t, f.issues)
+	alertID, err := getAlertID(n.Route(), payload)
+	require.NoError(t, err)
+	task, ok := n.Scheduler.Get(taskKey(taskCreate, "foo", "bar", alertID))
+	require.True(t, ok)
+	assert.WithinDuration(t, startsAt.Add(minFiring), task.At, time.Second)
+
+	// The alerts resolved before they had fired long enough never produce an issue.
+	resolved := testPayload(types.AlertStatusResolved)
+	resolved.Alerts[0].StartsAt = startsAt
+	resolved.Alerts[0].EndsAt = time.Now()
+	require.NoError(t, n.Notify(ctx, resolved, testParams))
+	assert.Empty(t, f.issues)
+	assert.Equal(t, 0, n.Scheduler.Len())
+
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	task, ok = n.Scheduler.Get(taskKey(taskCreate, "foo", "bar", alertID))
+	require.True(t, ok)
+	// The task is run once the alerts have fired long enough.
+	task.Payload.Alerts[0].StartsAt = time.Now().Add(-minFiring)
+	require.NoError(t, n.RunTask(ctx, task))
+	require.Len(t, f.issues, 1)
+	assert.Equal(t, "open", f.issues[0].GetState())
+
+	// The annotation overrides the route.
+	payload = testPayload(types.AlertStatusFiring)
+	payload.GroupKey = "group2"
+	payload.Alerts[0].StartsAt = time.Now()
+	payload.Alerts[0].Annotations = map[string]string{"atg_min_firing_duration": "0s"}
+	require.NoError(t, n.Notify(ctx, payload, testParams))
+	assert.Len(t, f.issues, 2)
+}
@@ -0,0 +1,203 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/index"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	alertIDMarkerRegexp = regexp.MustCompile(`<!-- \(UNIQUE ALERT ID, DO NOT MODIFY: ([0-9a-f]+) \) -->`)
+
+	indexLookupCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "issue_index_lookups_total",
+			Help: "Number of issue lookups in the local issue index.",
+		},
+		// result: "hit" or "miss"
+		[]string{"result"},
+	)
+)
+
+// findIssues returns the latest issue and the one before it for the alert.
+// indexed reports whether they were found in the index rather than by searching.
+func (n *GitHubNotifier) findIssues(ctx context.Context, owner, repo, alertID string, reopenWindow *time.Duration) (issue, previousIssue *github.Issue, indexed bool, err error) {
+	if n.Index != nil {
+		issue, previousIssue, err = n.lookupIndex(ctx, owner, repo, alertID)
+		if err != nil {
+			return nil, nil, false, err
+		}
+		if issue != nil {
+			indexLookupCount.WithLabelValues("hit").Inc()
+			return issue, previousIssue, true, nil
+		}
+		indexLookupCount.WithLabelValues("miss").Inc()
+	}
+
+	issue, previousIssue, err = n.searchIssues(ctx, owner, repo, alertID, reopenWindow)
+	if err != nil {
+		return nil, nil, false, err
+	}
+	if issue != nil {
+		if err := n.putIndex(owner, repo, alertID, issue, previousIssue); err != nil {
+			return nil, nil, false, err
+		}
+	}
+	return issue, previousIssue, false, nil
+}
+
+func (n *GitHubNotifier) lookupIndex(ctx context.Context, owner, repo, alertID string) (*github.Issue, *github.Issue, error) {
+	entry, ok, err := n.Index.Get(owner, repo, alertID)
+	if err != nil || !ok {
+		return nil, nil, err
+	}
+
+	issue, err := n.getIssue(ctx, owner, repo, entry.Number)
+	if err != nil {
+		return nil, nil, err
+	}
+	if issue == nil || !strings.Contains(issue.GetBody(), alertID) {
+		// The issue was deleted or rewritten by someone else.
+		log.Warn().Str("alertID", alertID).Int("number", entry.Number).Msg("discarding stale issue index entry")
+		return nil, nil, n.Index.Delete(owner, repo, alertID)
+	}
+
+	var previousIssue *github.Issue
+	if entry.PreviousNumber != 0 {
+		previousIssue, err = n.getIssue(ctx, owner, repo, entry.PreviousNumber)
+		if err != nil {
+			log.Warn().Err(err).Int("number", entry.PreviousNumber).Msg("failed to get the previous issue")
+		}
+	}
+	return issue, previousIssue, nil
+}
+
+// getIssue returns nil without an error if the issue does not exist.
+func (n *GitHubNotifier) getIssue(ctx context.Context, owner, repo string, number int) (*github.Issue, error) {
+	issue, response, err := n.GitHubClient.Issues.Get(ctx, owner, repo, number)
+	if response != nil {
+		updateGithubApiMetrics("issues", response)
+		if response.StatusCode == http.StatusNotFound || response.StatusCode == http.StatusGone {
+			return nil, nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+	return issue, nil
+}
+
+func (n *GitHubNotifier) searchIssues(ctx context.Context, owner, repo, alertID string, reopenWindow *time.Duration) (*github.Issue, *github.Issue, error) {
+	query := fmt.Sprintf(`repo:%s/%s "%s"`, owner, repo, alertID)
+	searchResult, response, err := n.GitHubClient.Search.Issues(ctx, query, &github.SearchOptions{
+		TextMatch: true,
+		Sort:      "created",
+		Order:     "desc",
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	updateGithubApiMetrics("search", response)
+	if err = checkSearchResponse(response); err != nil {
+		return nil, nil, err
+	}
+
+	issues := searchResult.Issues
+	sort.Slice(issues, func(i, j int) bool {
+		return issues[i].GetCreatedAt().After(issues[j].GetCreatedAt().Time)
+	})
+
+	var issue, previousIssue *github.Issue
+	if len(issues) == 1 {
+		issue = issues[0]
+	} else if len(issues) > 1 {
+		issue = issues[0]
+		previousIssue = issues[1]
+		if reopenWindow == nil {
+			// If issues are always reopened, the search result is expected to be unique.
+			log.Warn().Interface("searchResultTotal", searchResult.GetTotal()).
+				Str("alertID", alertID).Msg("too many search result")
+		}
+	}
+	return issue, previousIssue, nil
+}
+
+func (n *GitHubNotifier) putIndex(owner, repo, alertID string, issue, previousIssue *github.Issue) error {
+	if n.Index == nil || n.DryRun {
+		return nil
+	}
+
+	return n.Index.Put(owner, repo, alertID, &index.Entry{
+		Number:         issue.GetNumber(),
+		PreviousNumber: previousIssue.GetNumber(),
+	})
+}
+
+// BootstrapIndex adds the issues created by this receiver in the repository to the index.
+func (n *GitHubNotifier) BootstrapIndex(ctx context.Context, owner, repo string) error {
+	if n.Index == nil {
+		return nil
+	}
+
+	opts := &github.IssueListByRepoOptions{
+		State:       "all",
+		Sort:        "created",
+		Direction:   "asc",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	count := 0
+	for {
+		issues, response, err := n.GitHubClient.Issues.ListByRepo(ctx, owner, repo, opts)
+		if err != nil {
+			return err
+		}
+		updateGithubApiMetrics("issues", response)
+
+		for _, issue := range issues {
+			if issue.IsPullRequest() {
+				continue
+			}
+			m := alertIDMarkerRegexp.FindStringSubmatch(issue.GetBody())
+			if m == nil {
+				continue
+			}
+
+			alertID := m[1]
+			entry := &index.Entry{Number: issue.GetNumber()}
+			current, ok, err := n.Index.Get(owner, repo, alertID)
+			if err != nil {
+				return err
+			}
+			if ok {
+				if current.Number >= entry.Number {
+					// Issues may have been created while listing.
+					continue
+				}
+				entry.PreviousNumber = current.Number
+			}
+			if err := n.Index.Put(owner, repo, alertID, entry); err != nil {
+				return err
+			}
+			count++
+		}
+
+		if response.NextPage == 0 {
+			break
+		}
+		opts.Page = response.NextPage
+	}
+
+	log.Info().Str("owner", owner).Str("repo", repo).Int("issues", count).Msg("bootstrapped issue index")
+	return nil
+}
@@ -0,0 +1,107 @@
+package notifier
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/template"
+	"github.com/rs/zerolog/log"
+)
+
+// LabelFamily is a set of mutually exclusive labels such as status/firing and status/resolved.
+// The issue gets the label rendered from Template, and the other labels of the family are removed from it,
+// whereas the other labels are only ever added.
+type LabelFamily struct {
+	// Labels starting with Prefix belong to the family.
+	Prefix string
+	// Labels in Labels belong to the family.
+	Labels []string
+	// Template renders the label the issue should have. If it renders an empty string, the issue has no label of the family.
+	Template *template.Template
+}
+
+func (f *LabelFamily) Validate() error {
+	if f.Prefix == "" && len(f.Labels) == 0 {
+		return fmt.Errorf("label family must have either a prefix or labels")
+	}
+	if f.Template == nil {
+		return fmt.Errorf("label family template is not specified")
+	}
+	return nil
+}
+
+func (f *LabelFamily) contains(label string) bool {
+	if f.Prefix != "" && strings.HasPrefix(label, f.Prefix) {
+		return true
+	}
+	for _, l := range f.Labels {
+		if l == label {
+			return true
+		}
+	}
+	return false
+}
+
+func (f *LabelFamily) String() string {
+	if f.Prefix != "" {
+		return fmt.Sprintf("%s*", f.Prefix)
+	}
+	return fmt.Sprintf("[%s]", strings.Join(f.Labels, ", "))
+}
+
+// Render returns the label of the family the issue should have, or an empty string if it should have none.
+// It fails if the rendered label does not belong to the family.
+func (f *LabelFamily) Render(vars *template.Vars) (string, error) {
+	label, err := f.render(vars)
+	if err != nil {
+		return "", err
+	}
+	if label != "" && !f.contains(label) {
+		return "", fmt.Errorf("label %q does not belong to label family %s", label, f)
+	}
+	return label, nil
+}
+
+// render returns the label rendered from the template, which may not belong to the family.
+// The bare prefix, such as "severity/" rendered for alerts without the severity label, means no label.
+func (f *LabelFamily) render(vars *template.Vars) (string, error) {
+	s, err := f.Template.ExecuteVars(vars)
+	if err != nil {
+		return "", err
+	}
+	label := strings.TrimSpace(s)
+	if f.Prefix != "" && strings.TrimSpace(strings.TrimPrefix(label, f.Prefix)) == "" {
+		return "", nil
+	}
+	return label, nil
+}
+
+// familyLabels returns the labels of the families the issue should have,
+// and the labels of the families the issue has but should not have.
+// A family rendering a label which does not belong to it is logged and skipped, leaving the labels of the family as they are.
+func familyLabels(families []*LabelFamily, vars *template.Vars, issue *github.Issue) ([]string, []string, error) {
+	var labels, removed []string
+	for _, f := range families {
+		label, err := f.render(vars)
+		if err != nil {
+			return nil, nil, err
+		}
+		if label != "" && !f.contains(label) {
+			log.Warn().Str("label", label).Stringer("family", f).Msg("skipped a label which does not belong to the label family")
+			continue
+		}
+		if label != "" {
+			labels = append(labels, label)
+		}
+		if issue == nil {
+			continue
+		}
+		for _, l := range issue.Labels {
+			if name := l.GetName(); name != label && f.contains(name) {
+				removed = append(removed, name)
+			}
+		}
+	}
+	return labels, removed, nil
+}
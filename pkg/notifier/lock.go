@@ -0,0 +1,85 @@
+package notifier
+
+import (
+	"context"
+	"sync"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var coalescedCount = promauto.NewCounter(
+	prometheus.CounterOpts{
+		Name: "notifications_coalesced_total",
+		Help: "Number of notifications that shared the result of a concurrent identical notification.",
+	},
+)
+
+// alertLocks serializes work per alert ID.
+// Concurrent calls with the same alert ID and key share a single execution.
+type alertLocks struct {
+	mu    sync.Mutex
+	locks map[string]*alertLock
+}
+
+type alertLock struct {
+	refs  int
+	sem   chan struct{}
+	calls map[string]*lockedCall
+}
+
+type lockedCall struct {
+	done chan struct{}
+	err  error
+}
+
+func (l *alertLocks) do(ctx context.Context, alertID string, key string, fn func() error) error {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = map[string]*alertLock{}
+	}
+	lock, ok := l.locks[alertID]
+	if !ok {
+		lock = &alertLock{
+			sem:   make(chan struct{}, 1),
+			calls: map[string]*lockedCall{},
+		}
+		l.locks[alertID] = lock
+	}
+	if c, ok := lock.calls[key]; ok {
+		l.mu.Unlock()
+		coalescedCount.Inc()
+		select {
+		case <-c.done:
+			return c.err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	c := &lockedCall{done: make(chan struct{})}
+	lock.calls[key] = c
+	lock.refs++
+	l.mu.Unlock()
+
+	defer func() {
+		l.mu.Lock()
+		delete(lock.calls, key)
+		lock.refs--
+		if lock.refs == 0 {
+			delete(l.locks, alertID)
+		}
+		l.mu.Unlock()
+		close(c.done)
+	}()
+
+	select {
+	case lock.sem <- struct{}{}:
+	case <-ctx.Done():
+		c.err = ctx.Err()
+		return c.err
+	}
+	defer func() { <-lock.sem }()
+
+	c.err = fn()
+	return c.err
+}
@@ -0,0 +1,79 @@
+package notifier
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlertLocksSerialize(t *testing.T) {
+	var locks alertLocks
+	var running, maxRunning int32
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			err := locks.do(context.Background(), "alert1", string(rune('a'+i)), func() error {
+				n := atomic.AddInt32(&running, 1)
+				for {
+					m := atomic.LoadInt32(&maxRunning)
+					if n <= m || atomic.CompareAndSwapInt32(&maxRunning, m, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				atomic.AddInt32(&running, -1)
+				return nil
+			})
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int32(1), maxRunning)
+	assert.Empty(t, locks.locks)
+}
+
+func TestAlertLocksCoalesce(t *testing.T) {
+	var locks alertLocks
+	var calls int32
+	release := make(chan struct{})
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		_ = locks.do(context.Background(), "alert1", "key", func() error {
+			<-release
+			atomic.AddInt32(&calls, 1)
+			return nil
+		})
+	}()
+	assert.Eventually(t, func() bool {
+		locks.mu.Lock()
+		defer locks.mu.Unlock()
+		return len(locks.locks) == 1
+	}, time.Second, time.Millisecond)
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_ = locks.do(context.Background(), "alert1", "key", func() error {
+				atomic.AddInt32(&calls, 1)
+				return nil
+			})
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	assert.Equal(t, int32(1), calls)
+}
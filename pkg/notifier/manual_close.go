@@ -0,0 +1,81 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+// ManualClosePolicy decides what happens to an issue which humans have closed while its alerts are still firing.
+type ManualClosePolicy string
+
+const (
+	// ManualCloseReopen reopens the issue with a comment explaining why.
+	ManualCloseReopen ManualClosePolicy = "reopen"
+	// ManualCloseRespectUntilResolved keeps the issue closed until the alert group resolves and fires again.
+	ManualCloseRespectUntilResolved ManualClosePolicy = "respect-until-resolved"
+	// ManualCloseRespectForDuration keeps the issue closed for the manual close duration after it was closed.
+	ManualCloseRespectForDuration ManualClosePolicy = "respect-for-duration"
+)
+
+func ParseManualClosePolicy(s string) (ManualClosePolicy, error) {
+	switch p := ManualClosePolicy(s); p {
+	case ManualCloseReopen, ManualCloseRespectUntilResolved, ManualCloseRespectForDuration:
+		return p, nil
+	default:
+		return "", fmt.Errorf("unknown manual close policy %q: must be %s, %s or %s",
+			s, ManualCloseReopen, ManualCloseRespectUntilResolved, ManualCloseRespectForDuration)
+	}
+}
+
+// isManuallyClosed returns true if the issue has been closed since the receiver last saw the alerts firing.
+// The receiver itself only closes issues of resolved alerts.
+func isManuallyClosed(issue *github.Issue, lastState *issueState) bool {
+	return isClosed(issue) && lastState != nil && lastState.Status == types.AlertStatusFiring
+}
+
+// reopenManuallyClosed returns whether the manually closed issue of firing alerts should be reopened,
+// and if so, the comment explaining why.
+func (n *GitHubNotifier) reopenManuallyClosed(ctx context.Context, nf *notification, issue *github.Issue) (bool, string) {
+	policy := nf.route.manualClosePolicy()
+	closedAt := issue.GetClosedAt().Time
+	switch policy {
+	case ManualCloseRespectUntilResolved:
+		return false, ""
+	case ManualCloseRespectForDuration:
+		if time.Since(closedAt) < nf.route.manualCloseDuration() {
+			return false, ""
+		}
+	}
+
+	by := "someone"
+	if login := n.closedBy(ctx, nf.owner, nf.repo, issue); login != "" {
+		by = "@" + login
+	}
+	if policy == ManualCloseRespectForDuration {
+		return true, fmt.Sprintf("Reopened because the alerts are still firing %s after this issue was closed by %s.",
+			nf.route.manualCloseDuration(), by)
+	}
+	return true, fmt.Sprintf("Reopened because the alerts are still firing. This issue was closed by %s at %s, "+
+		"but it is kept open while the alerts fire. To stop notifications, resolve the alerts or silence them in Alertmanager.",
+		by, closedAt.UTC().Format(time.RFC3339))
+}
+
+// closedBy returns the login of the user who closed the issue, or an empty string if it is unknown.
+// Issues found with the Search API do not have ClosedBy, so they are fetched again.
+func (n *GitHubNotifier) closedBy(ctx context.Context, owner, repo string, issue *github.Issue) string {
+	if issue.ClosedBy != nil {
+		return issue.GetClosedBy().GetLogin()
+	}
+	fetched, response, err := n.GitHubClient.Issues.Get(ctx, owner, repo, issue.GetNumber())
+	if err != nil {
+		log.Warn().Err(err).Msgf("failed to get who closed the issue: %s", issue.GetURL())
+		return ""
+	}
+	updateGithubApiMetrics("issues", response)
+	return fetched.GetClosedBy().GetLogin()
+}
@@ -0,0 +1,283 @@
+package notifier
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
+)
+
+// metadataFetchTimeout bounds a fetch of repository metadata, which is shared by the notifications waiting for it.
+const metadataFetchTimeout = time.Minute
+
+// repositoryMetadata caches the labels, milestones and assignees of repositories,
+// which the values rendered from the label, assignee and milestone templates are validated against.
+type repositoryMetadata struct {
+	// mu guards repos and the caches in it. It is not held while the metadata is fetched,
+	// so that notifications for other repositories are not blocked by slow API calls.
+	mu    sync.Mutex
+	repos map[string]*repositoryCache
+	// fetches deduplicates concurrent fetches of the same metadata.
+	fetches singleflight.Group
+}
+
+// repositoryCache is the metadata of a repository. The fetched maps are never modified, so they can be read without the lock.
+type repositoryCache struct {
+	fetchedAt time.Time
+	// Lowercased label names, since labels are matched case-insensitively. Nil until fetched.
+	labels map[string]bool
+	// Milestone numbers by title. Nil until fetched.
+	milestones map[string]int
+	// Whether each login can be assigned. Logins are checked one by one as they are rendered.
+	assignees map[string]bool
+}
+
+// issueFields are the labels, assignees and milestone rendered from the templates of the route.
+type issueFields struct {
+	labels    []string
+	assignees []string
+	milestone *int
+}
+
+// splitList splits a rendered template into the values separated by commas or newlines.
+// Empty and duplicated values are dropped.
+func splitList(s string) []string {
+	var values []string
+	seen := map[string]bool{}
+	for _, v := range strings.FieldsFunc(s, func(r rune) bool { return r == ',' || r == '\n' }) {
+		v = strings.TrimSpace(v)
+		if v != "" && !seen[v] {
+			seen[v] = true
+			values = append(values, v)
+		}
+	}
+	return values
+}
+
+// renderIssueFields renders the label, assignee and milestone templates of the route.
+// Values which do not exist in the repository are logged and skipped, so that they do not fail the notification.
+func (n *GitHubNotifier) renderIssueFields(ctx context.Context, nf *notification, previousIssue *github.Issue) (*issueFields, error) {
+	fields := &issueFields{}
+	route := nf.route
+	if route.LabelsTemplate == nil && route.AssigneesTemplate == nil && route.MilestoneTemplate == nil {
+		return fields, nil
+	}
+
+	vars := nf.templateVars(previousIssue)
+	logger := log.With().Str("owner", nf.owner).Str("repo", nf.repo).Logger()
+
+	cache := n.repositoryCache(nf.owner, nf.repo)
+
+	if route.LabelsTemplate != nil {
+		s, err := route.LabelsTemplate.ExecuteVars(vars)
+		if err != nil {
+			return nil, err
+		}
+		if labels := splitList(s); len(labels) > 0 {
+			existing, err := n.labels(ctx, nf.owner, nf.repo, cache)
+			if err != nil {
+				return nil, err
+			}
+			for _, l := range labels {
+				if !existing[strings.ToLower(l)] {
+					logger.Warn().Str("label", l).Msg("skipped a label which does not exist in the repository")
+					continue
+				}
+				fields.labels = append(fields.labels, l)
+			}
+		}
+	}
+
+	if route.AssigneesTemplate != nil {
+		s, err := route.AssigneesTemplate.ExecuteVars(vars)
+		if err != nil {
+			return nil, err
+		}
+		for _, login := range splitList(s) {
+			login = strings.TrimPrefix(login, "@")
+			ok, err := n.isAssignee(ctx, nf.owner, nf.repo, login, cache)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				logger.Warn().Str("assignee", login).Msg("skipped a user who cannot be assigned in the repository")
+				continue
+			}
+			fields.assignees = append(fields.assignees, login)
+		}
+	}
+
+	if route.MilestoneTemplate != nil {
+		s, err := route.MilestoneTemplate.ExecuteVars(vars)
+		if err != nil {
+			return nil, err
+		}
+		if title := strings.TrimSpace(s); title != "" {
+			milestones, err := n.milestones(ctx, nf.owner, nf.repo, cache)
+			if err != nil {
+				return nil, err
+			}
+			if number, ok := milestones[title]; ok {
+				fields.milestone = &number
+			} else {
+				logger.Warn().Str("milestone", title).Msg("skipped a milestone which does not exist in the repository")
+			}
+		}
+	}
+	return fields, nil
+}
+
+// repositoryCache returns the cache of the repository, discarding it if it is older than MetadataCacheTTL.
+func (n *GitHubNotifier) repositoryCache(owner, repo string) *repositoryCache {
+	n.metadata.mu.Lock()
+	defer n.metadata.mu.Unlock()
+
+	if n.metadata.repos == nil {
+		n.metadata.repos = map[string]*repositoryCache{}
+	}
+	key := owner + "/" + repo
+	cache, ok := n.metadata.repos[key]
+	if !ok || time.Since(cache.fetchedAt) >= n.MetadataCacheTTL {
+		cache = &repositoryCache{
+			fetchedAt: time.Now(),
+			assignees: map[string]bool{},
+		}
+		n.metadata.repos[key] = cache
+	}
+	return cache
+}
+
+// sharedFetchContext returns the context of a fetch shared by several callers.
+// It is not canceled with the caller which started it, so that it does not fail the others waiting for it.
+func sharedFetchContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), metadataFetchTimeout)
+}
+
+// labels returns the labels of the repository, fetching them unless they are cached.
+func (n *GitHubNotifier) labels(ctx context.Context, owner, repo string, cache *repositoryCache) (map[string]bool, error) {
+	n.metadata.mu.Lock()
+	labels := cache.labels
+	n.metadata.mu.Unlock()
+	if labels != nil {
+		return labels, nil
+	}
+
+	v, err, _ := n.metadata.fetches.Do("labels:"+owner+"/"+repo, func() (interface{}, error) {
+		ctx, cancel := sharedFetchContext(ctx)
+		defer cancel()
+		labels, err := n.fetchLabels(ctx, owner, repo)
+		if err != nil {
+			return nil, err
+		}
+		n.metadata.mu.Lock()
+		cache.labels = labels
+		n.metadata.mu.Unlock()
+		return labels, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]bool), nil
+}
+
+func (n *GitHubNotifier) fetchLabels(ctx context.Context, owner, repo string) (map[string]bool, error) {
+	labels := map[string]bool{}
+	opts := &github.ListOptions{PerPage: 100}
+	for {
+		page, response, err := n.GitHubClient.Issues.ListLabels(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		updateGithubApiMetrics("issues", response)
+		for _, l := range page {
+			labels[strings.ToLower(l.GetName())] = true
+		}
+		if response.NextPage == 0 {
+			break
+		}
+		opts.Page = response.NextPage
+	}
+	return labels, nil
+}
+
+// milestones returns the open milestones of the repository, fetching them unless they are cached.
+func (n *GitHubNotifier) milestones(ctx context.Context, owner, repo string, cache *repositoryCache) (map[string]int, error) {
+	n.metadata.mu.Lock()
+	milestones := cache.milestones
+	n.metadata.mu.Unlock()
+	if milestones != nil {
+		return milestones, nil
+	}
+
+	v, err, _ := n.metadata.fetches.Do("milestones:"+owner+"/"+repo, func() (interface{}, error) {
+		ctx, cancel := sharedFetchContext(ctx)
+		defer cancel()
+		milestones, err := n.fetchMilestones(ctx, owner, repo)
+		if err != nil {
+			return nil, err
+		}
+		n.metadata.mu.Lock()
+		cache.milestones = milestones
+		n.metadata.mu.Unlock()
+		return milestones, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(map[string]int), nil
+}
+
+func (n *GitHubNotifier) fetchMilestones(ctx context.Context, owner, repo string) (map[string]int, error) {
+	milestones := map[string]int{}
+	opts := &github.MilestoneListOptions{
+		State:       "open",
+		ListOptions: github.ListOptions{PerPage: 100},
+	}
+	for {
+		page, response, err := n.GitHubClient.Issues.ListMilestones(ctx, owner, repo, opts)
+		if err != nil {
+			return nil, err
+		}
+		updateGithubApiMetrics("issues", response)
+		for _, m := range page {
+			milestones[m.GetTitle()] = m.GetNumber()
+		}
+		if response.NextPage == 0 {
+			break
+		}
+		opts.Page = response.NextPage
+	}
+	return milestones, nil
+}
+
+// isAssignee returns whether the user can be assigned in the repository, checking it unless it is cached.
+func (n *GitHubNotifier) isAssignee(ctx context.Context, owner, repo, login string, cache *repositoryCache) (bool, error) {
+	n.metadata.mu.Lock()
+	ok, cached := cache.assignees[login]
+	n.metadata.mu.Unlock()
+	if cached {
+		return ok, nil
+	}
+
+	v, err, _ := n.metadata.fetches.Do("assignee:"+owner+"/"+repo+"/"+login, func() (interface{}, error) {
+		ctx, cancel := sharedFetchContext(ctx)
+		defer cancel()
+		ok, response, err := n.GitHubClient.Issues.IsAssignee(ctx, owner, repo, login)
+		if err != nil {
+			return false, err
+		}
+		updateGithubApiMetrics("issues", response)
+		n.metadata.mu.Lock()
+		cache.assignees[login] = ok
+		n.metadata.mu.Unlock()
+		return ok, nil
+	})
+	if err != nil {
+		return false, err
+	}
+	return v.(bool), nil
+}
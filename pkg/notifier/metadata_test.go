@@ -0,0 +1,67 @@
+package notifier
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRepositoryMetadataFetches(t *testing.T) {
+	release := make(chan struct{})
+	var slowFetches atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/repos/foo/slow/labels" {
+			slowFetches.Add(1)
+			<-release
+		}
+		_, _ = w.Write([]byte(`[{"name": "bug"}]`))
+	}))
+	defer server.Close()
+
+	n, err := NewGitHub()
+	require.NoError(t, err)
+	n.GitHubClient = github.NewClient(nil)
+	n.GitHubClient.BaseURL, err = url.Parse(server.URL + "/")
+	require.NoError(t, err)
+	n.MetadataCacheTTL = time.Hour
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	fetch := func(ctx context.Context) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			labels, err := n.labels(ctx, "foo", "slow", n.repositoryCache("foo", "slow"))
+			assert.NoError(t, err)
+			assert.True(t, labels["bug"])
+		}()
+	}
+	firstCtx, cancelFirst := context.WithCancel(ctx)
+	fetch(firstCtx)
+	require.Eventually(t, func() bool { return slowFetches.Load() == 1 }, time.Second, time.Millisecond)
+	for i := 0; i < 4; i++ {
+		fetch(ctx)
+	}
+
+	// A slow repository does not block the others.
+	labels, err := n.labels(ctx, "foo", "fast", n.repositoryCache("foo", "fast"))
+	require.NoError(t, err)
+	assert.True(t, labels["bug"])
+
+	// Concurrent fetches of the same repository are made once,
+	// and they do not fail even if the caller which started the fetch is canceled.
+	time.Sleep(50 * time.Millisecond)
+	cancelFirst()
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), slowFetches.Load())
+}
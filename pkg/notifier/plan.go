@@ -0,0 +1,261 @@
+package notifier
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+// Plan is the list of GitHub writes a notification would have made in dry-run mode.
+type Plan struct {
+	Time     time.Time         `json:"time"`
+	Owner    string            `json:"owner"`
+	Repo     string            `json:"repo"`
+	AlertID  string            `json:"alertID"`
+	GroupKey string            `json:"groupKey"`
+	Status   types.AlertStatus `json:"status"`
+	Actions  []*PlanAction     `json:"actions"`
+}
+
+type PlanAction struct {
+	// "create", "edit", "close", "reopen", "comment", "delay close" or "delay create"
+	Type          string   `json:"type"`
+	IssueNumber   int      `json:"issueNumber,omitempty"`
+	Title         *string  `json:"title,omitempty"`
+	Body          *string  `json:"body,omitempty"`
+	AddedLabels   []string `json:"addedLabels,omitempty"`
+	RemovedLabels []string `json:"removedLabels,omitempty"`
+	// The assignees which would replace the current ones.
+	Assignees *[]string `json:"assignees,omitempty"`
+	Milestone *int      `json:"milestone,omitempty"`
+	// When the delayed action would be taken.
+	At *time.Time `json:"at,omitempty"`
+}
+
+// PlanRecorder keeps the latest plans.
+type PlanRecorder struct {
+	mu    sync.Mutex
+	plans []*Plan
+	next  int
+	full  bool
+}
+
+func NewPlanRecorder(size int) *PlanRecorder {
+	if size < 1 {
+		size = 1
+	}
+	return &PlanRecorder{plans: make([]*Plan, size)}
+}
+
+func (r *PlanRecorder) Record(plan *Plan) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.plans[r.next] = plan
+	r.next = (r.next + 1) % len(r.plans)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// Plans returns the recorded plans, newest first.
+func (r *PlanRecorder) Plans() []*Plan {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	n := r.next
+	if r.full {
+		n = len(r.plans)
+	}
+	plans := make([]*Plan, 0, n)
+	for i := 1; i <= n; i++ {
+		plans = append(plans, r.plans[(r.next-i+len(r.plans))%len(r.plans)])
+	}
+	return plans
+}
+
+type planContextKey struct{}
+
+func withPlan(ctx context.Context, plan *Plan) context.Context {
+	return context.WithValue(ctx, planContextKey{}, plan)
+}
+
+func planFrom(ctx context.Context) *Plan {
+	plan, _ := ctx.Value(planContextKey{}).(*Plan)
+	return plan
+}
+
+// startPlan returns a context in which GitHub writes are recorded to a plan instead of being made,
+// and a function to finish the plan. The context is returned as is unless in dry-run mode.
+func (n *GitHubNotifier) startPlan(ctx context.Context, payload *types.WebhookPayload, owner, repo, alertID string) (context.Context, func()) {
+	if !n.DryRun {
+		return ctx, func() {}
+	}
+
+	plan := &Plan{
+		Time:     time.Now(),
+		Owner:    owner,
+		Repo:     repo,
+		AlertID:  alertID,
+		GroupKey: payload.GroupKey,
+		Status:   payload.Status,
+		Actions:  []*PlanAction{},
+	}
+	return withPlan(ctx, plan), func() {
+		log.Info().Interface("plan", plan).Msg("dry run: planned GitHub actions")
+		if n.Plans != nil {
+			n.Plans.Record(plan)
+		}
+	}
+}
+
+func (n *GitHubNotifier) createIssue(ctx context.Context, owner, repo string, req *github.IssueRequest) (*github.Issue, error) {
+	if plan := planFrom(ctx); plan != nil {
+		plan.Actions = append(plan.Actions, &PlanAction{
+			Type:        "create",
+			Title:       req.Title,
+			Body:        req.Body,
+			AddedLabels: derefLabels(req.Labels),
+			Assignees:   req.Assignees,
+			Milestone:   req.Milestone,
+		})
+		issue := &github.Issue{
+			State: github.String("open"),
+			Title: req.Title,
+			Body:  req.Body,
+		}
+		setIssueLabels(issue, derefLabels(req.Labels))
+		return issue, nil
+	}
+
+	issue, response, err := n.GitHubClient.Issues.Create(ctx, owner, repo, req)
+	if err != nil {
+		return nil, err
+	}
+	updateGithubApiMetrics("issues", response)
+	log.Info().Msgf("created an issue: %s", issue.GetURL())
+	return issue, nil
+}
+
+func (n *GitHubNotifier) editIssue(ctx context.Context, owner, repo string, issue *github.Issue, req *github.IssueRequest) (*github.Issue, error) {
+	actionType := "edit"
+	if req.State != nil {
+		actionType = "reopen"
+		if *req.State == "closed" {
+			actionType = "close"
+		}
+	}
+
+	if plan := planFrom(ctx); plan != nil {
+		action := &PlanAction{
+			Type:        actionType,
+			IssueNumber: issue.GetNumber(),
+			Title:       req.Title,
+			Body:        req.Body,
+		}
+		edited := *issue
+		if req.Title != nil {
+			edited.Title = req.Title
+		}
+		if req.Body != nil {
+			edited.Body = req.Body
+		}
+		if req.State != nil {
+			edited.State = req.State
+		}
+		if req.Labels != nil {
+			action.AddedLabels, action.RemovedLabels = diffLabels(issueLabels(issue), *req.Labels)
+			setIssueLabels(&edited, *req.Labels)
+		}
+		action.Milestone = req.Milestone
+		if req.Assignees != nil {
+			action.Assignees = req.Assignees
+			edited.Assignees = nil
+			for _, login := range *req.Assignees {
+				edited.Assignees = append(edited.Assignees, &github.User{Login: github.String(login)})
+			}
+		}
+		plan.Actions = append(plan.Actions, action)
+		return &edited, nil
+	}
+
+	edited, response, err := n.GitHubClient.Issues.Edit(ctx, owner, repo, issue.GetNumber(), req)
+	if err != nil {
+		return nil, err
+	}
+	updateGithubApiMetrics("issues", response)
+	switch actionType {
+	case "close":
+		log.Info().Msgf("closed an issue: %s", edited.GetURL())
+	case "reopen":
+		log.Info().Msgf("reopened an issue: %s", edited.GetURL())
+	default:
+		log.Info().Msgf("edited an issue: %s", edited.GetURL())
+	}
+	return edited, nil
+}
+
+func (n *GitHubNotifier) createComment(ctx context.Context, owner, repo string, issue *github.Issue, body string) error {
+	if plan := planFrom(ctx); plan != nil {
+		plan.Actions = append(plan.Actions, &PlanAction{
+			Type:        "comment",
+			IssueNumber: issue.GetNumber(),
+			Body:        &body,
+		})
+		return nil
+	}
+
+	comment, response, err := n.GitHubClient.Issues.CreateComment(ctx, owner, repo, issue.GetNumber(), &github.IssueComment{Body: &body})
+	if err != nil {
+		return err
+	}
+	updateGithubApiMetrics("issues", response)
+	log.Info().Msgf("commented on an issue: %s", comment.GetHTMLURL())
+	return nil
+}
+
+func issueLabels(issue *github.Issue) []string {
+	labels := []string{}
+	for _, l := range issue.Labels {
+		labels = append(labels, l.GetName())
+	}
+	return labels
+}
+
+func setIssueLabels(issue *github.Issue, labels []string) {
+	issue.Labels = nil
+	for _, l := range labels {
+		issue.Labels = append(issue.Labels, &github.Label{Name: github.String(l)})
+	}
+}
+
+func derefLabels(labels *[]string) []string {
+	if labels == nil {
+		return nil
+	}
+	return *labels
+}
+
+func diffLabels(current, desired []string) (added, removed []string) {
+	currentSet := map[string]bool{}
+	for _, l := range current {
+		currentSet[l] = true
+	}
+	desiredSet := map[string]bool{}
+	for _, l := range desired {
+		desiredSet[l] = true
+		if !currentSet[l] {
+			added = append(added, l)
+		}
+	}
+	for _, l := range current {
+		if !desiredSet[l] {
+			removed = append(removed, l)
+		}
+	}
+	return added, removed
+}
@@ -0,0 +1,68 @@
+package notifier
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/index"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPlanRecorder(t *testing.T) {
+	r := NewPlanRecorder(2)
+	assert.Empty(t, r.Plans())
+
+	r.Record(&Plan{AlertID: "1"})
+	r.Record(&Plan{AlertID: "2"})
+	assert.Equal(t, []*Plan{{AlertID: "2"}, {AlertID: "1"}}, r.Plans())
+
+	r.Record(&Plan{AlertID: "3"})
+	assert.Equal(t, []*Plan{{AlertID: "3"}, {AlertID: "2"}}, r.Plans())
+}
+
+func TestNotifyDryRun(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.Index = index.NewMemory()
+	n.Plans = NewPlanRecorder(10)
+	ctx := context.Background()
+
+	n.DryRun = true
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+	assert.Empty(t, f.issues)
+	assert.Zero(t, f.countRequests("POST"))
+	assert.Zero(t, f.countRequests("PATCH"))
+	plans := n.Plans.Plans()
+	require.Len(t, plans, 1)
+	require.Len(t, plans[0].Actions, 1)
+	assert.Equal(t, "create", plans[0].Actions[0].Type)
+	assert.Equal(t, "[ALERT] group1", *plans[0].Actions[0].Title)
+
+	n.DryRun = false
+	n.Route().Labels = []string{"alert"}
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusFiring), testParams))
+	require.Len(t, f.issues, 1)
+
+	n.DryRun = true
+	n.Route().Labels = []string{"alert", "new"}
+	require.NoError(t, n.Notify(ctx, testPayload(types.AlertStatusResolved), testParams))
+	assert.Equal(t, "open", f.issues[0].GetState())
+	assert.True(t, strings.HasPrefix(f.issues[0].GetBody(), managedSection("firing")))
+	assert.Zero(t, f.countRequests("PATCH"))
+
+	plans = n.Plans.Plans()
+	require.Len(t, plans, 2)
+	require.Len(t, plans[0].Actions, 2)
+	assert.Equal(t, "edit", plans[0].Actions[0].Type)
+	assert.Equal(t, f.issues[0].GetNumber(), plans[0].Actions[0].IssueNumber)
+	assert.Equal(t, []string{"new"}, plans[0].Actions[0].AddedLabels)
+	assert.Equal(t, "close", plans[0].Actions[1].Type)
+}
+
+func TestDiffLabels(t *testing.T) {
+	added, removed := diffLabels([]string{"a", "b"}, []string{"b", "c"})
+	assert.Equal(t, []string{"c"}, added)
+	assert.Equal(t, []string{"a"}, removed)
+}
@@ -0,0 +1,86 @@
+package notifier
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var repositoryPolicyViolationCount = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "repository_policy_violations_total",
+		Help: "Number of notifications targeting a repository rejected by the repository policy.",
+	},
+	// action: "fallback" if the issue was created in the fallback repository, "rejected" otherwise
+	[]string{"action"},
+)
+
+// RepositoryPolicy restricts the repositories in which issues may be created.
+//
+// A pattern without a slash matches owners (e.g. "foo" or "team-*"), and a pattern
+// with a slash matches repositories (e.g. "foo/bar" or "foo/alerts-*").
+// Patterns use the syntax of path.Match and are case-insensitive.
+type RepositoryPolicy struct {
+	// If not empty, only repositories matching one of these patterns are allowed.
+	Allow []string
+	// Repositories matching one of these patterns are rejected even if they are allowed.
+	Deny []string
+	// If set, issues for rejected repositories are created in this repository instead.
+	FallbackOwner string
+	FallbackRepo  string
+}
+
+// Validate checks the syntax of the patterns.
+func (p *RepositoryPolicy) Validate() error {
+	for _, pattern := range append(append([]string{}, p.Allow...), p.Deny...) {
+		if strings.Count(pattern, "/") > 1 {
+			return fmt.Errorf("invalid repository pattern %q: must be owner or owner/repo", pattern)
+		}
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid repository pattern %q: %w", pattern, err)
+		}
+	}
+	if (p.FallbackOwner == "") != (p.FallbackRepo == "") {
+		return fmt.Errorf("fallback repository must have both owner and repo")
+	}
+	return nil
+}
+
+// Check returns an error if the repository is not allowed.
+func (p *RepositoryPolicy) Check(owner, repo string) error {
+	if p == nil {
+		return nil
+	}
+
+	if matchRepository(p.Deny, owner, repo) {
+		return fmt.Errorf("repository %s/%s is denied by the repository policy", owner, repo)
+	}
+	if len(p.Allow) > 0 && !matchRepository(p.Allow, owner, repo) {
+		return fmt.Errorf("repository %s/%s is not allowed by the repository policy", owner, repo)
+	}
+	return nil
+}
+
+func (p *RepositoryPolicy) hasFallback() bool {
+	return p != nil && p.FallbackOwner != "" && p.FallbackRepo != ""
+}
+
+func matchRepository(patterns []string, owner, repo string) bool {
+	owner = strings.ToLower(owner)
+	fullName := owner + "/" + strings.ToLower(repo)
+	for _, pattern := range patterns {
+		pattern = strings.ToLower(pattern)
+		name := fullName
+		if !strings.Contains(pattern, "/") {
+			name = owner
+		}
+		// Patterns are validated in advance.
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
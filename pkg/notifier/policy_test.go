@@ -0,0 +1,49 @@
+package notifier
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRepositoryPolicyCheck(t *testing.T) {
+	policy := &RepositoryPolicy{
+		Allow: []string{"foo", "bar/alerts-*"},
+		Deny:  []string{"foo/secret"},
+	}
+	assert.NoError(t, policy.Validate())
+
+	tests := []struct {
+		owner   string
+		repo    string
+		allowed bool
+	}{
+		{owner: "foo", repo: "anything", allowed: true},
+		{owner: "Foo", repo: "Anything", allowed: true},
+		{owner: "foo", repo: "secret", allowed: false},
+		{owner: "bar", repo: "alerts-prod", allowed: true},
+		{owner: "bar", repo: "other", allowed: false},
+		{owner: "baz", repo: "alerts-prod", allowed: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.owner+"/"+tt.repo, func(t *testing.T) {
+			err := policy.Check(tt.owner, tt.repo)
+			if tt.allowed {
+				assert.NoError(t, err)
+			} else {
+				assert.Error(t, err)
+			}
+		})
+	}
+
+	var nilPolicy *RepositoryPolicy
+	assert.NoError(t, nilPolicy.Check("foo", "bar"))
+	assert.NoError(t, (&RepositoryPolicy{Deny: []string{"foo"}}).Check("bar", "baz"))
+}
+
+func TestRepositoryPolicyValidate(t *testing.T) {
+	assert.Error(t, (&RepositoryPolicy{Allow: []string{"foo/bar/baz"}}).Validate())
+	assert.Error(t, (&RepositoryPolicy{Deny: []string{"foo/[bar"}}).Validate())
+	assert.Error(t, (&RepositoryPolicy{FallbackOwner: "foo"}).Validate())
+	assert.NoError(t, (&RepositoryPolicy{FallbackOwner: "foo", FallbackRepo: "bar"}).Validate())
+}
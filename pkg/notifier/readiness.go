@@ -0,0 +1,53 @@
+package notifier
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// readiness caches the result of the readiness check.
+type readiness struct {
+	mu        sync.Mutex
+	checkedAt time.Time
+	err       error
+}
+
+// CheckReady returns an error unless the GitHub client can authenticate and has rate limit remaining.
+// The result is cached for ReadinessCacheTTL so that probes do not consume the API quota.
+func (n *GitHubNotifier) CheckReady(ctx context.Context) error {
+	n.readiness.mu.Lock()
+	defer n.readiness.mu.Unlock()
+
+	if !n.readiness.checkedAt.IsZero() && time.Since(n.readiness.checkedAt) < n.ReadinessCacheTTL {
+		return n.readiness.err
+	}
+
+	n.readiness.err = n.checkReady(ctx)
+	n.readiness.checkedAt = time.Now()
+	if n.readiness.err != nil {
+		log.Warn().Err(n.readiness.err).Msg("not ready")
+	}
+	return n.readiness.err
+}
+
+func (n *GitHubNotifier) checkReady(ctx context.Context) error {
+	limits, response, err := n.GitHubClient.RateLimits(ctx)
+	if response != nil && response.StatusCode == http.StatusNotFound {
+		// GitHub Enterprise Server returns 404 if rate limiting is disabled.
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to get rate limits: %w", err)
+	}
+
+	core := limits.GetCore()
+	if core != nil && core.Remaining <= 0 {
+		return fmt.Errorf("rate limit exhausted until %s", core.Reset.Format(time.RFC3339))
+	}
+	return nil
+}
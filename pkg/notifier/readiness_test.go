@@ -0,0 +1,29 @@
+package notifier
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckReady(t *testing.T) {
+	n, f := newTestNotifier(t)
+	n.ReadinessCacheTTL = time.Hour
+	ctx := context.Background()
+
+	assert.NoError(t, n.CheckReady(ctx))
+	assert.NoError(t, n.CheckReady(ctx))
+	assert.Equal(t, 1, f.countRequests("GET /rate_limit"))
+
+	f.mu.Lock()
+	f.rateLimitRemaining = 0
+	f.mu.Unlock()
+	// The cached result is used.
+	assert.NoError(t, n.CheckReady(ctx))
+
+	n.ReadinessCacheTTL = 0
+	assert.ErrorContains(t, n.CheckReady(ctx), "rate limit exhausted")
+	assert.Equal(t, 2, f.countRequests("GET /rate_limit"))
+}
@@ -0,0 +1,268 @@
+package notifier
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/matcher"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/template"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+)
+
+// Route decides how issues are created for the alert groups matching Matchers.
+// Unset fields are inherited from the parent route.
+type Route struct {
+	// Matchers are evaluated against the common labels of the alert group.
+	// They are ignored on the root route, which matches every alert group.
+	Matchers matcher.Matchers
+
+	Owner           string
+	Repo            string
+	Labels          []string
+	TitleTemplate   *template.Template
+	BodyTemplate    *template.Template
+	AlertIDTemplate *template.Template
+	// Labels of each family replace each other instead of being added to the issue like Labels.
+	LabelFamilies []*LabelFamily
+	// LabelsTemplate and AssigneesTemplate render lists separated by commas or newlines, and MilestoneTemplate
+	// renders a milestone title. The values which do not exist in the repository are skipped.
+	LabelsTemplate    *template.Template
+	AssigneesTemplate *template.Template
+	MilestoneTemplate *template.Template
+
+	AutoCloseResolvedIssues *bool
+	// If set, resolved issues are closed only if the alerts do not fire again within the delay.
+	AutoCloseDelay *time.Duration
+	// If set, issues are created only for alerts which have been firing for at least this long.
+	MinFiringDuration *time.Duration
+	// If nil, closed issues are always reopened.
+	ReopenWindow *time.Duration
+	// What happens to issues closed by humans while their alerts are firing. Empty means ManualCloseReopen.
+	ManualClosePolicy ManualClosePolicy
+	// How long ManualCloseRespectForDuration keeps issues closed.
+	ManualCloseDuration *time.Duration
+	// If true, an issue is created for each alert rather than for each alert group.
+	// Alerts are identified by their labels, and the alert ID template is not used.
+	IssuePerAlert *bool
+	// If true, the body is rendered only when the issue is created,
+	// and changes of the alerts are posted as comments rendered from CommentTemplate.
+	CommentOnChanges *bool
+	CommentTemplate  *template.Template
+
+	// Child routes are evaluated in order, and the first matching one is used.
+	Routes []*Route
+}
+
+// match returns the routes from r to the deepest matching route.
+func (r *Route) match(labels map[string]string) []*Route {
+	for _, child := range r.Routes {
+		if child.Matchers.Matches(labels) {
+			return append([]*Route{r}, child.match(labels)...)
+		}
+	}
+	return []*Route{r}
+}
+
+// Inherit returns a copy of r with the unset fields taken from parent.
+func (r *Route) Inherit(parent *Route) *Route {
+	merged := *parent
+	merged.Matchers = r.Matchers
+	merged.Routes = r.Routes
+	if r.Owner != "" {
+		merged.Owner = r.Owner
+	}
+	if r.Repo != "" {
+		merged.Repo = r.Repo
+	}
+	if r.Labels != nil {
+		merged.Labels = r.Labels
+	}
+	if r.LabelFamilies != nil {
+		merged.LabelFamilies = r.LabelFamilies
+	}
+	if r.LabelsTemplate != nil {
+		merged.LabelsTemplate = r.LabelsTemplate
+	}
+	if r.AssigneesTemplate != nil {
+		merged.AssigneesTemplate = r.AssigneesTemplate
+	}
+	if r.MilestoneTemplate != nil {
+		merged.MilestoneTemplate = r.MilestoneTemplate
+	}
+	if r.TitleTemplate != nil {
+		merged.TitleTemplate = r.TitleTemplate
+	}
+	if r.BodyTemplate != nil {
+		merged.BodyTemplate = r.BodyTemplate
+	}
+	if r.AlertIDTemplate != nil {
+		merged.AlertIDTemplate = r.AlertIDTemplate
+	}
+	if r.AutoCloseResolvedIssues != nil {
+		merged.AutoCloseResolvedIssues = r.AutoCloseResolvedIssues
+	}
+	if r.AutoCloseDelay != nil {
+		merged.AutoCloseDelay = r.AutoCloseDelay
+	}
+	if r.MinFiringDuration != nil {
+		merged.MinFiringDuration = r.MinFiringDuration
+	}
+	if r.ReopenWindow != nil {
+		merged.ReopenWindow = r.ReopenWindow
+	}
+	if r.ManualClosePolicy != "" {
+		merged.ManualClosePolicy = r.ManualClosePolicy
+	}
+	if r.ManualCloseDuration != nil {
+		merged.ManualCloseDuration = r.ManualCloseDuration
+	}
+	if r.IssuePerAlert != nil {
+		merged.IssuePerAlert = r.IssuePerAlert
+	}
+	if r.CommentOnChanges != nil {
+		merged.CommentOnChanges = r.CommentOnChanges
+	}
+	if r.CommentTemplate != nil {
+		merged.CommentTemplate = r.CommentTemplate
+	}
+	return &merged
+}
+
+// Validate checks that the root route has everything required to create issues.
+func (r *Route) Validate() error {
+	if r.TitleTemplate == nil {
+		return fmt.Errorf("title template is not specified")
+	}
+	if r.BodyTemplate == nil {
+		return fmt.Errorf("body template is not specified")
+	}
+	if r.AlertIDTemplate == nil {
+		return fmt.Errorf("alert ID template is not specified")
+	}
+	return nil
+}
+
+// resolveRoute returns the effective settings for the payload.
+//
+// The webhook URL parameters override the root route, and the matching child routes override them.
+func resolveRoute(root *Route, payload *types.WebhookPayload, queryParams url.Values) *Route {
+	path := root.match(payload.CommonLabels)
+
+	route := &Route{}
+	if owner := queryParams.Get("owner"); owner != "" {
+		route.Owner = owner
+	}
+	if repo := queryParams.Get("repo"); repo != "" {
+		route.Repo = repo
+	}
+	if l := queryParams.Get("labels"); l != "" {
+		route.Labels = strings.Split(l, ",")
+	}
+	route = route.Inherit(root)
+	for _, child := range path[1:] {
+		route = child.Inherit(route)
+	}
+	route.Matchers = nil
+	route.Routes = nil
+
+	if route.Labels == nil {
+		route.Labels = []string{}
+	}
+	return route
+}
+
+// target is a repository and the alerts to notify there.
+type target struct {
+	owner   string
+	repo    string
+	payload *types.WebhookPayload
+}
+
+// splitByRepository partitions the alerts by the repository given by their atg_owner/atg_repo labels,
+// which override the route. Each partition gets a payload with the common labels and annotations of its alerts.
+// Alerts without a repository are dropped with an error.
+func splitByRepository(payload *types.WebhookPayload, route *Route) ([]*target, error) {
+	repository := func(labels map[string]string) (string, string, error) {
+		owner, repo := route.Owner, route.Repo
+		if labels[ownerLabelName] != "" {
+			owner = labels[ownerLabelName]
+		}
+		if labels[repoLabelName] != "" {
+			repo = labels[repoLabelName]
+		}
+		if owner == "" {
+			return "", "", fmt.Errorf("owner was not specified in either the webhook URL, the route, or the alert labels")
+		}
+		if repo == "" {
+			return "", "", fmt.Errorf("repo was not specified in either the webhook URL, the route, or the alert labels")
+		}
+		return owner, repo, nil
+	}
+
+	if len(payload.Alerts) == 0 {
+		owner, repo, err := repository(payload.CommonLabels)
+		if err != nil {
+			return nil, err
+		}
+		return []*target{{owner: owner, repo: repo, payload: payload}}, nil
+	}
+
+	var targets []*target
+	alerts := map[string][]types.WebhookAlert{}
+	var err error
+	dropped := 0
+	for _, alert := range payload.Alerts {
+		owner, repo, repoErr := repository(alert.Labels)
+		if repoErr != nil {
+			err = repoErr
+			dropped++
+			continue
+		}
+		key := owner + "/" + repo
+		if _, ok := alerts[key]; !ok {
+			targets = append(targets, &target{owner: owner, repo: repo})
+		}
+		alerts[key] = append(alerts[key], alert)
+	}
+	if err != nil {
+		err = fmt.Errorf("dropped %d alerts: %w", dropped, err)
+	}
+
+	if len(targets) == 1 && err == nil {
+		// Keep the payload as is if it is not split.
+		targets[0].payload = payload
+		return targets, nil
+	}
+	for _, t := range targets {
+		t.payload = payload.SubPayload(alerts[t.owner+"/"+t.repo])
+	}
+	return targets, err
+}
+
+func (r *Route) autoCloseResolvedIssues() bool {
+	return r.AutoCloseResolvedIssues != nil && *r.AutoCloseResolvedIssues
+}
+
+func (r *Route) issuePerAlert() bool {
+	return r.IssuePerAlert != nil && *r.IssuePerAlert
+}
+
+func (r *Route) commentOnChanges() bool {
+	return r.CommentOnChanges != nil && *r.CommentOnChanges
+}
+
+func (r *Route) manualClosePolicy() ManualClosePolicy {
+	if r.ManualClosePolicy == "" {
+		return ManualCloseReopen
+	}
+	return r.ManualClosePolicy
+}
+
+func (r *Route) manualCloseDuration() time.Duration {
+	if r.ManualCloseDuration == nil {
+		return 0
+	}
+	return *r.ManualCloseDuration
+}
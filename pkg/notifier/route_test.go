@@ -0,0 +1,130 @@
+package notifier
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResolveRoute(t *testing.T) {
+	root := &Route{
+		Owner:                   "default-owner",
+		Labels:                  []string{"alert"},
+		AutoCloseResolvedIssues: github.Bool(true),
+		Routes: []*Route{
+			{
+				Matchers:                mustParseMatchers(t, `team="db"`),
+				Repo:                    "db",
+				AutoCloseResolvedIssues: github.Bool(false),
+			},
+			{
+				Matchers: mustParseMatchers(t, `team=~".+"`),
+				Owner:    "teams",
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		labels      map[string]string
+		queryParams url.Values
+		owner       string
+		repo        string
+		labelNames  []string
+		autoClose   bool
+	}{
+		{
+			name:        "root",
+			labels:      map[string]string{},
+			queryParams: url.Values{"repo": {"bar"}},
+			owner:       "default-owner",
+			repo:        "bar",
+			labelNames:  []string{"alert"},
+			autoClose:   true,
+		},
+		{
+			name:        "query params override the root route",
+			labels:      map[string]string{},
+			queryParams: url.Values{"owner": {"foo"}, "repo": {"bar"}, "labels": {"a,b"}},
+			owner:       "foo",
+			repo:        "bar",
+			labelNames:  []string{"a", "b"},
+			autoClose:   true,
+		},
+		{
+			name:        "the first matching route is used",
+			labels:      map[string]string{"team": "db"},
+			queryParams: url.Values{"owner": {"foo"}, "repo": {"bar"}},
+			owner:       "foo",
+			repo:        "db",
+			labelNames:  []string{"alert"},
+			autoClose:   false,
+		},
+		{
+			name:        "routes override query params",
+			labels:      map[string]string{"team": "web"},
+			queryParams: url.Values{"owner": {"foo"}, "repo": {"bar"}},
+			owner:       "teams",
+			repo:        "bar",
+			labelNames:  []string{"alert"},
+			autoClose:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			payload := &types.WebhookPayload{CommonLabels: tt.labels}
+			route := resolveRoute(root, payload, tt.queryParams)
+			assert.Equal(t, tt.owner, route.Owner)
+			assert.Equal(t, tt.repo, route.Repo)
+			assert.Equal(t, tt.labelNames, route.Labels)
+			assert.Equal(t, tt.autoClose, route.autoCloseResolvedIssues())
+		})
+	}
+}
+
+func TestSplitByRepository(t *testing.T) {
+	route := &Route{Owner: "foo", Repo: "bar"}
+	alert := func(labels map[string]string) types.WebhookAlert {
+		return types.WebhookAlert{Status: types.AlertStatusFiring, Labels: labels}
+	}
+
+	payload := &types.WebhookPayload{
+		CommonLabels: map[string]string{"alertname": "Test", "atg_repo": "r"},
+		Alerts: []types.WebhookAlert{
+			alert(map[string]string{"alertname": "Test", "atg_repo": "r"}),
+		},
+	}
+	targets, err := splitByRepository(payload, route)
+	require.NoError(t, err)
+	require.Len(t, targets, 1)
+	assert.Equal(t, "foo", targets[0].owner)
+	assert.Equal(t, "r", targets[0].repo)
+	assert.Same(t, payload, targets[0].payload)
+
+	payload = &types.WebhookPayload{
+		CommonLabels: map[string]string{"alertname": "Test"},
+		Alerts: []types.WebhookAlert{
+			alert(map[string]string{"alertname": "Test", "instance": "a", "atg_repo": "r1"}),
+			alert(map[string]string{"alertname": "Test", "instance": "b"}),
+			alert(map[string]string{"alertname": "Test", "instance": "c", "atg_owner": "o", "atg_repo": "r1"}),
+			alert(map[string]string{"alertname": "Test", "instance": "d", "atg_repo": "r1"}),
+		},
+	}
+	targets, err = splitByRepository(payload, route)
+	require.NoError(t, err)
+	require.Len(t, targets, 3)
+	assert.Equal(t, []string{"foo", "r1"}, []string{targets[0].owner, targets[0].repo})
+	assert.Len(t, targets[0].payload.Alerts, 2)
+	assert.Equal(t, map[string]string{"alertname": "Test", "atg_repo": "r1"}, targets[0].payload.CommonLabels)
+	assert.Equal(t, []string{"foo", "bar"}, []string{targets[1].owner, targets[1].repo})
+	assert.Equal(t, "b", targets[1].payload.CommonLabels["instance"])
+	assert.Equal(t, []string{"o", "r1"}, []string{targets[2].owner, targets[2].repo})
+
+	_, err = splitByRepository(payload, &Route{Owner: "foo"})
+	assert.Error(t, err)
+}
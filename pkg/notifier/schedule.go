@@ -0,0 +1,206 @@
+package notifier
+
+import (
+	"context"
+	"time"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/scheduler"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/rs/zerolog/log"
+)
+
+// Kinds of scheduled tasks.
+const (
+	// Close the issue of a resolved alert unless it fires again in the meantime.
+	taskClose = "close"
+	// Create the issue of a firing alert unless it is resolved in the meantime.
+	taskCreate = "create"
+)
+
+func taskKey(kind, owner, repo, alertID string) string {
+	return kind + ":" + owner + "/" + repo + "/" + alertID
+}
+
+// newTask returns the task making the notification again at the time.
+func newTask(key, kind string, at time.Time, nf *notification) *scheduler.Task {
+	return &scheduler.Task{
+		Key:         key,
+		Kind:        kind,
+		At:          at,
+		Owner:       nf.owner,
+		Repo:        nf.repo,
+		Note:        nf.note,
+		Payload:     nf.payload,
+		Params:      nf.params,
+		AlertID:     nf.alertID,
+		PerAlert:    nf.alert != nil,
+		RouteLabels: nf.routeLabels,
+	}
+}
+
+// backgroundContext returns the context of a notification made in the background.
+// It is not canceled with ctx on shutdown, so that the issue is not left half updated, but bounded by NotifyTimeout.
+func (n *GitHubNotifier) backgroundContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx = context.WithoutCancel(ctx)
+	if n.NotifyTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, n.NotifyTimeout)
+}
+
+// annotatedDuration returns the duration given by the annotation of the alerts, or by the route if there is no annotation.
+func annotatedDuration(nf *notification, annotation func() (time.Duration, bool, error), routeValue *time.Duration) time.Duration {
+	d, ok, err := annotation()
+	if err != nil {
+		log.Warn().Err(err).Str("alertID", nf.alertID).Msg("ignoring the annotation")
+	}
+	if ok && err == nil {
+		return d
+	}
+	if routeValue != nil {
+		return *routeValue
+	}
+	return 0
+}
+
+// delayClose schedules the issue to be closed later, and returns false if it should be closed now.
+func (n *GitHubNotifier) delayClose(ctx context.Context, nf *notification) (bool, error) {
+	delay := annotatedDuration(nf, nf.payload.AutoCloseDelay, nf.route.AutoCloseDelay)
+	if delay <= 0 || n.Scheduler == nil {
+		return false, nil
+	}
+
+	key := taskKey(taskClose, nf.owner, nf.repo, nf.alertID)
+	at := time.Now().Add(delay)
+	task, pending := n.Scheduler.Get(key)
+	if pending {
+		// Resent resolved notifications do not postpone the close.
+		at = task.At
+	}
+
+	if plan := planFrom(ctx); plan != nil {
+		plan.Actions = append(plan.Actions, &PlanAction{Type: "delay close", At: &at})
+		return true, nil
+	}
+	if pending {
+		return true, nil
+	}
+
+	err := n.Scheduler.Schedule(newTask(key, taskClose, at, nf))
+	if err != nil {
+		return false, err
+	}
+	log.Info().Str("alertID", nf.alertID).Time("at", at).Msg("delayed closing an issue")
+	return true, nil
+}
+
+// delayCreate returns true if the issue should not be created yet, scheduling it to be created later if the alerts are still firing.
+func (n *GitHubNotifier) delayCreate(ctx context.Context, nf *notification) (bool, error) {
+	minFiring := annotatedDuration(nf, nf.payload.MinFiringDuration, nf.route.MinFiringDuration)
+	now := time.Now()
+	firing := nf.payload.FiringDuration(now)
+	if minFiring <= 0 || firing >= minFiring {
+		return false, nil
+	}
+
+	if nf.payload.Status == types.AlertStatusResolved {
+		// The alerts were resolved before they had fired long enough.
+		log.Info().Str("alertID", nf.alertID).Msg("skipped creating an issue for short-lived alerts")
+		return true, nil
+	}
+
+	at := now.Add(minFiring - firing)
+	if plan := planFrom(ctx); plan != nil {
+		plan.Actions = append(plan.Actions, &PlanAction{Type: "delay create", At: &at})
+		return true, nil
+	}
+	if n.Scheduler == nil {
+		// The alerts are evaluated again on the next notification.
+		return true, nil
+	}
+
+	// The latest notification replaces the pending one, so that the issue is created from the latest alerts.
+	err := n.Scheduler.Schedule(newTask(taskKey(taskCreate, nf.owner, nf.repo, nf.alertID), taskCreate, at, nf))
+	if err != nil {
+		return false, err
+	}
+	log.Info().Str("alertID", nf.alertID).Time("at", at).Msg("delayed creating an issue")
+	return true, nil
+}
+
+// closePending returns true if closing the issue has been delayed and the scheduled close has not run yet.
+func (n *GitHubNotifier) closePending(nf *notification) bool {
+	if n.Scheduler == nil || nf.scheduled {
+		return false
+	}
+	_, pending := n.Scheduler.Get(taskKey(taskClose, nf.owner, nf.repo, nf.alertID))
+	return pending
+}
+
+func (n *GitHubNotifier) cancelTask(kind, owner, repo, alertID string) error {
+	if n.Scheduler == nil || n.DryRun {
+		return nil
+	}
+	canceled, err := n.Scheduler.Cancel(taskKey(kind, owner, repo, alertID))
+	if err != nil {
+		return err
+	}
+	if canceled {
+		log.Info().Str("alertID", alertID).Str("kind", kind).Msg("canceled a scheduled task")
+	}
+	return nil
+}
+
+// taskNotifications returns the notifications of the task.
+// The route is resolved from the labels of the whole group, and the alert ID is the one the task was scheduled for,
+// since the payload of the task may be a part of the group.
+func (n *GitHubNotifier) taskNotifications(task *scheduler.Task) ([]*notification, error) {
+	if task.AlertID == "" {
+		// The task was stored by an older version.
+		route := resolveRoute(n.Route(), task.Payload, task.Params)
+		return newNotifications(task.Payload, route, task.Owner, task.Repo, task.Note)
+	}
+
+	routePayload := *task.Payload
+	routePayload.CommonLabels = task.RouteLabels
+	nf := &notification{
+		payload:     task.Payload,
+		route:       resolveRoute(n.Route(), &routePayload, task.Params),
+		routeLabels: task.RouteLabels,
+		owner:       task.Owner,
+		repo:        task.Repo,
+		alertID:     task.AlertID,
+		note:        task.Note,
+	}
+	if task.PerAlert && len(task.Payload.Alerts) == 1 {
+		nf.alert = &task.Payload.Alerts[0]
+	}
+	return []*notification{nf}, nil
+}
+
+// RunTask notifies the payload of the task again now that its delay has elapsed.
+// The notification is not canceled with ctx, but bounded by NotifyTimeout.
+func (n *GitHubNotifier) RunTask(ctx context.Context, task *scheduler.Task) error {
+	nfs, err := n.taskNotifications(task)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := n.backgroundContext(ctx)
+	defer cancel()
+	for _, nf := range nfs {
+		nf.params = task.Params
+		nf.scheduled = true
+		err := n.locks.do(ctx, nf.alertID, "scheduled", func() error {
+			// The task may have been canceled while waiting for the lock.
+			if pending, ok := n.Scheduler.Get(task.Key); !ok || pending != task {
+				return nil
+			}
+			return n.notify(ctx, nf)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,148 @@
+package notifier
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"sort"
+	"time"
+
+	"github.com/google/go-github/v54/github"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/template"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+)
+
+const issueStateMarkerFormat = "<!-- (ALERT STATE, DO NOT MODIFY: %s ) -->\n"
+
+var issueStateMarkerRegexp = regexp.MustCompile(`<!-- \(ALERT STATE, DO NOT MODIFY: (.*?) \) -->\n?`)
+
+// issueState is what this receiver last wrote to the issue. It is kept in the issue body.
+type issueState struct {
+	Status types.AlertStatus `json:"status"`
+	// Fingerprints of the firing alerts.
+	Alerts []string `json:"alerts"`
+	// The title as it was last rendered, to tell whether humans have changed it.
+	Title string `json:"title,omitempty"`
+	// Hash of what the issue was rendered from. See contentHash.
+	Hash string `json:"hash,omitempty"`
+	// Whether the issue was acknowledged. See Acknowledgment.
+	Acknowledged bool `json:"acknowledged,omitempty"`
+	// The assignees rendered from the assignee template, to tell them from the ones humans have added.
+	// Human assignees kept when an acknowledgment is cleared are included, so that they do not acknowledge the issue again.
+	Assignees []string `json:"assignees,omitempty"`
+}
+
+func newIssueState(payload *types.WebhookPayload, title string) *issueState {
+	state := &issueState{
+		Status: payload.Status,
+		Alerts: []string{},
+		Title:  title,
+	}
+	for _, alert := range payload.Alerts {
+		if alert.Status == types.AlertStatusFiring {
+			state.Alerts = append(state.Alerts, alert.Fingerprint())
+		}
+	}
+	return state
+}
+
+// parseIssueState returns nil if the body has no valid state, e.g. it was created by an older version.
+func parseIssueState(body string) *issueState {
+	m := issueStateMarkerRegexp.FindStringSubmatch(body)
+	if m == nil {
+		return nil
+	}
+	state := &issueState{}
+	if err := json.Unmarshal([]byte(m[1]), state); err != nil {
+		return nil
+	}
+	return state
+}
+
+func (s *issueState) marker() (string, error) {
+	// json.Marshal escapes '<' and '>', so the JSON never terminates the comment.
+	b, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf(issueStateMarkerFormat, b), nil
+}
+
+// replaceIssueState replaces the state in the body, or appends it if there is none.
+func replaceIssueState(body string, marker string) string {
+	if loc := issueStateMarkerRegexp.FindStringIndex(body); loc != nil {
+		return body[:loc[0]] + marker + body[loc[1]:]
+	}
+	return body + "\n" + marker
+}
+
+// changes returns how the alerts in the payload have changed since the state.
+func (s *issueState) changes(payload *types.WebhookPayload) *types.AlertChanges {
+	firing := map[string]bool{}
+	for _, fp := range s.Alerts {
+		firing[fp] = true
+	}
+
+	changes := &types.AlertChanges{}
+	for _, alert := range payload.Alerts {
+		wasFiring := firing[alert.Fingerprint()]
+		if alert.Status == types.AlertStatusFiring && !wasFiring {
+			changes.Added = append(changes.Added, alert)
+		} else if alert.Status == types.AlertStatusResolved && wasFiring {
+			changes.Resolved = append(changes.Resolved, alert)
+		}
+	}
+	changes.GroupResolved = s.Status == types.AlertStatusFiring && payload.Status == types.AlertStatusResolved
+	changes.GroupRefired = s.Status == types.AlertStatusResolved && payload.Status == types.AlertStatusFiring
+	return changes
+}
+
+// contentHash returns a hash of everything the issue is rendered from, so that an unchanged issue is not edited again.
+// It hashes the inputs rather than the rendered output, which may contain volatile values such as timeNow.
+func contentHash(nf *notification, previousIssue *github.Issue) (string, error) {
+	payload := *nf.payload
+	payload.Alerts = make([]types.WebhookAlert, len(nf.payload.Alerts))
+	copy(payload.Alerts, nf.payload.Alerts)
+	for i := range payload.Alerts {
+		// Alertmanager pushes EndsAt of firing alerts forward on every resend.
+		if payload.Alerts[i].Status == types.AlertStatusFiring {
+			payload.Alerts[i].EndsAt = time.Time{}
+		}
+	}
+	sort.SliceStable(payload.Alerts, func(i, j int) bool {
+		return payload.Alerts[i].Fingerprint() < payload.Alerts[j].Fingerprint()
+	})
+
+	source := func(t *template.Template) string {
+		if t == nil {
+			return ""
+		}
+		return t.Source()
+	}
+	b, err := json.Marshal(struct {
+		Payload           *types.WebhookPayload
+		PreviousIssue     int
+		Labels            []string
+		Note              string
+		TitleTemplate     string
+		BodyTemplate      string
+		CommentTemplate   string
+		AssigneesTemplate string
+		MilestoneTemplate string
+	}{
+		Payload:           &payload,
+		PreviousIssue:     previousIssue.GetNumber(),
+		Labels:            nf.route.Labels,
+		Note:              nf.note,
+		TitleTemplate:     source(nf.route.TitleTemplate),
+		BodyTemplate:      source(nf.route.BodyTemplate),
+		CommentTemplate:   source(nf.route.CommentTemplate),
+		AssigneesTemplate: source(nf.route.AssigneesTemplate),
+		MilestoneTemplate: source(nf.route.MilestoneTemplate),
+	})
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
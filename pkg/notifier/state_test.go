@@ -0,0 +1,45 @@
+package notifier
+
+import (
+	"testing"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestIssueState(t *testing.T) {
+	payload := testPayload(types.AlertStatusFiring)
+	payload.Alerts[0].Labels["msg"] = "--> <b>"
+	state := newIssueState(payload, "title")
+	marker, err := state.marker()
+	require.NoError(t, err)
+
+	body := replaceIssueState("body\n", marker)
+	assert.Equal(t, state, parseIssueState(body))
+	// The marker is replaced rather than appended again.
+	assert.Equal(t, body, replaceIssueState(body, marker))
+	assert.Nil(t, parseIssueState("body\n"))
+
+	resolved := testPayload(types.AlertStatusResolved)
+	resolved.Alerts[0].Labels["msg"] = "--> <b>"
+	changes := state.changes(resolved)
+	assert.Empty(t, changes.Added)
+	assert.Len(t, changes.Resolved, 1)
+	assert.True(t, changes.GroupResolved)
+	assert.False(t, changes.GroupRefired)
+
+	assert.True(t, state.changes(payload).Empty())
+}
+
+func TestReplaceManagedSection(t *testing.T) {
+	body := "before\n" + managedSection("old") + "after\n"
+	replaced, ok := replaceManagedSection(body, "new")
+	assert.True(t, ok)
+	assert.Equal(t, "before\n"+managedSection("new")+"after\n", replaced)
+
+	_, ok = replaceManagedSection("old", "new")
+	assert.False(t, ok)
+	_, ok = replaceManagedSection(managedSectionBegin+"old", "new")
+	assert.False(t, ok)
+}
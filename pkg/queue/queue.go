@@ -0,0 +1,530 @@
+package queue
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	walFileName        = "queue.wal"
+	deadLetterFileName = "dead-letter.jsonl"
+
+	opPut = "put"
+	opAck = "ack"
+
+	// The log is rewritten once it holds this many records more than the pending items need.
+	compactThreshold = 1024
+)
+
+var (
+	queueDepthDesc = prometheus.NewDesc(
+		"webhook_queue_depth",
+		"Number of webhook payloads waiting in the queue.",
+		nil, nil,
+	)
+	queueOldestAgeDesc = prometheus.NewDesc(
+		"webhook_queue_oldest_item_age_seconds",
+		"Age of the oldest webhook payload waiting in the queue.",
+		nil, nil,
+	)
+	processedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_queue_processed_total",
+			Help: "Number of attempts to process queued webhook payloads.",
+		},
+		// result: "success" or "failure"
+		[]string{"result"},
+	)
+	droppedCount = promauto.NewCounterVec(
+		prometheus.CounterOpts{
+			Name: "webhook_queue_dropped_total",
+			Help: "Number of queued webhook payloads given up on and written to the dead letter file.",
+		},
+		// reason: "permanent" if the error cannot be fixed by retrying, or "max_attempts"
+		[]string{"reason"},
+	)
+)
+
+type Item struct {
+	ID         uint64                `json:"id"`
+	Payload    *types.WebhookPayload `json:"payload"`
+	Params     url.Values            `json:"params"`
+	EnqueuedAt time.Time             `json:"enqueuedAt"`
+}
+
+type Handler func(context.Context, *Item) error
+
+type record struct {
+	Op   string `json:"op"`
+	ID   uint64 `json:"id"`
+	Item *Item  `json:"item,omitempty"`
+}
+
+type entry struct {
+	item        *Item
+	attempts    int
+	nextAttempt time.Time
+	inFlight    bool
+}
+
+// Queue is a persistent FIFO of webhook payloads backed by a write-ahead log.
+// Payloads of the same alert group are processed in the order they were enqueued.
+type Queue struct {
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	// A failed item is dropped after MaxAttempts attempts. 0 means no limit.
+	MaxAttempts int
+	// If set, an item failing with an error for which IsPermanent returns true is dropped without retrying.
+	IsPermanent func(error) bool
+
+	mu      sync.Mutex
+	dir     string
+	file    *os.File
+	nextID  uint64
+	entries map[uint64]*entry
+	records int
+	wake    chan struct{}
+}
+
+// Open loads the queue stored in dir, creating the directory if necessary.
+func Open(dir string) (*Queue, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	q := &Queue{
+		InitialBackoff: time.Second,
+		MaxBackoff:     5 * time.Minute,
+		dir:            dir,
+		nextID:         1,
+		entries:        map[uint64]*entry{},
+		wake:           make(chan struct{}, 1),
+	}
+	if err := q.replay(); err != nil {
+		return nil, err
+	}
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+
+	if len(q.entries) > 0 {
+		log.Info().Int("items", len(q.entries)).Msg("restored webhook queue")
+	}
+	return q, nil
+}
+
+func (q *Queue) walPath() string {
+	return filepath.Join(q.dir, walFileName)
+}
+
+func (q *Queue) replay() error {
+	f, err := os.Open(q.walPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to close queue log")
+		}
+	}()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var r record
+		if err := json.Unmarshal(scanner.Bytes(), &r); err != nil {
+			// A torn write at the tail of the log is expected after a crash.
+			log.Warn().Err(err).Msg("skipping corrupted queue log record")
+			continue
+		}
+
+		switch r.Op {
+		case opPut:
+			if r.Item == nil {
+				continue
+			}
+			q.entries[r.ID] = &entry{item: r.Item}
+		case opAck:
+			delete(q.entries, r.ID)
+		}
+		if r.ID >= q.nextID {
+			q.nextID = r.ID + 1
+		}
+	}
+	return scanner.Err()
+}
+
+// compact rewrites the log so that it only contains pending items.
+// It must be called with q.mu held.
+func (q *Queue) compact() error {
+	tmpPath := q.walPath() + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(tmp)
+	for _, e := range q.sortedEntries() {
+		if err := writeRecord(w, &record{Op: opPut, ID: e.item.ID, Item: e.item}); err != nil {
+			_ = tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if q.file != nil {
+		if err := q.file.Close(); err != nil {
+			return err
+		}
+		q.file = nil
+	}
+	if err := os.Rename(tmpPath, q.walPath()); err != nil {
+		return err
+	}
+	if err := syncDir(q.dir); err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(q.walPath(), os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	q.file = f
+	q.records = len(q.entries)
+	return nil
+}
+
+func (q *Queue) sortedEntries() []*entry {
+	entries := make([]*entry, 0, len(q.entries))
+	for _, e := range q.entries {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].item.ID < entries[j].item.ID
+	})
+	return entries
+}
+
+// append writes a record to the log. It must be called with q.mu held.
+func (q *Queue) append(r *record) error {
+	if q.file == nil {
+		return errors.New("queue is closed")
+	}
+	if err := writeRecord(q.file, r); err != nil {
+		return err
+	}
+	if err := q.file.Sync(); err != nil {
+		return err
+	}
+	q.records++
+	return nil
+}
+
+// Enqueue persists the payload. Once it returns without error, the payload survives a restart.
+func (q *Queue) Enqueue(payload *types.WebhookPayload, params url.Values) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	item := &Item{
+		ID:         q.nextID,
+		Payload:    payload,
+		Params:     params,
+		EnqueuedAt: time.Now(),
+	}
+	if err := q.append(&record{Op: opPut, ID: item.ID, Item: item}); err != nil {
+		return fmt.Errorf("failed to write queue log: %w", err)
+	}
+	q.nextID++
+	q.entries[item.ID] = &entry{item: item}
+
+	q.notify()
+	return nil
+}
+
+func (q *Queue) ack(id uint64) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.append(&record{Op: opAck, ID: id}); err != nil {
+		return err
+	}
+	delete(q.entries, id)
+
+	if q.records-len(q.entries) > compactThreshold {
+		return q.compact()
+	}
+	return nil
+}
+
+// retry schedules the next attempt of the item, and returns false if it has run out of attempts.
+func (q *Queue) retry(id uint64) (time.Duration, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	e, ok := q.entries[id]
+	if !ok {
+		return 0, true
+	}
+	e.inFlight = false
+	e.attempts++
+	if q.MaxAttempts > 0 && e.attempts >= q.MaxAttempts {
+		return 0, false
+	}
+	backoff := q.backoff(e.attempts)
+	e.nextAttempt = time.Now().Add(backoff)
+	return backoff, true
+}
+
+// drop writes the item to the dead letter file and removes it from the queue,
+// so that the later payloads of the same alert group are no longer held back by it.
+func (q *Queue) drop(item *Item, reason string) error {
+	droppedCount.WithLabelValues(reason).Inc()
+
+	f, err := os.OpenFile(filepath.Join(q.dir, deadLetterFileName), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if err := writeRecord(f, &record{Op: reason, ID: item.ID, Item: item}); err != nil {
+		_ = f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return q.ack(item.ID)
+}
+
+func (q *Queue) backoff(attempts int) time.Duration {
+	d := q.InitialBackoff
+	for i := 1; i < attempts; i++ {
+		d *= 2
+		if d >= q.MaxBackoff {
+			return q.MaxBackoff
+		}
+	}
+	return d
+}
+
+// next returns the first item which is ready to be processed, and otherwise
+// how long to wait until one becomes ready.
+func (q *Queue) next(now time.Time) (*Item, time.Duration) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	wait := time.Duration(-1)
+	blocked := map[string]bool{}
+	for _, e := range q.sortedEntries() {
+		key := e.item.Payload.GroupKey
+		if blocked[key] {
+			continue
+		}
+		// Later payloads of the same group must wait for this one.
+		blocked[key] = true
+
+		if e.inFlight {
+			continue
+		}
+		if d := e.nextAttempt.Sub(now); d > 0 {
+			if wait < 0 || d < wait {
+				wait = d
+			}
+			continue
+		}
+
+		e.inFlight = true
+		return e.item, 0
+	}
+	return nil, wait
+}
+
+func (q *Queue) release(id uint64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if e, ok := q.entries[id]; ok {
+		e.inFlight = false
+	}
+}
+
+func (q *Queue) notify() {
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run drains the queue with the given number of workers until ctx is canceled.
+// Failed items are retried with exponential backoff. Items being processed when
+// ctx is canceled are allowed to finish.
+func (q *Queue) Run(ctx context.Context, workers int, handler Handler) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	jobs := make(chan *Item)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range jobs {
+				q.process(context.WithoutCancel(ctx), item, handler)
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	for {
+		item, wait := q.next(time.Now())
+		if item != nil {
+			select {
+			case jobs <- item:
+			case <-ctx.Done():
+				q.release(item.ID)
+				return
+			}
+			continue
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-q.wake:
+		case <-timeout:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
+
+func (q *Queue) process(ctx context.Context, item *Item, handler Handler) {
+	err := handler(ctx, item)
+	if err != nil {
+		processedCount.WithLabelValues("failure").Inc()
+		logger := log.With().Uint64("id", item.ID).Str("groupKey", item.Payload.GroupKey).Logger()
+
+		reason := ""
+		if q.IsPermanent != nil && q.IsPermanent(err) {
+			reason = "permanent"
+		} else if backoff, ok := q.retry(item.ID); ok {
+			logger.Error().Err(err).Dur("backoff", backoff).Msg("failed to process queued payload")
+		} else {
+			reason = "max_attempts"
+		}
+		if reason != "" {
+			logger.Error().Err(err).Str("reason", reason).Msg("dropped queued payload to the dead letter file")
+			if err := q.drop(item, reason); err != nil {
+				logger.Error().Err(err).Msg("failed to drop queued payload")
+			}
+		}
+	} else {
+		processedCount.WithLabelValues("success").Inc()
+		if err := q.ack(item.ID); err != nil {
+			log.Error().Err(err).Uint64("id", item.ID).Msg("failed to acknowledge queued payload")
+		}
+	}
+	q.notify()
+}
+
+// Len returns the number of pending items, including the ones being processed.
+func (q *Queue) Len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return len(q.entries)
+}
+
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.file == nil {
+		return nil
+	}
+	err := q.file.Close()
+	q.file = nil
+	return err
+}
+
+func (q *Queue) Describe(ch chan<- *prometheus.Desc) {
+	ch <- queueDepthDesc
+	ch <- queueOldestAgeDesc
+}
+
+func (q *Queue) Collect(ch chan<- prometheus.Metric) {
+	q.mu.Lock()
+	depth := len(q.entries)
+	var oldest time.Time
+	for _, e := range q.entries {
+		if oldest.IsZero() || e.item.EnqueuedAt.Before(oldest) {
+			oldest = e.item.EnqueuedAt
+		}
+	}
+	q.mu.Unlock()
+
+	age := 0.0
+	if !oldest.IsZero() {
+		age = time.Since(oldest).Seconds()
+	}
+	ch <- prometheus.MustNewConstMetric(queueDepthDesc, prometheus.GaugeValue, float64(depth))
+	ch <- prometheus.MustNewConstMetric(queueOldestAgeDesc, prometheus.GaugeValue, age)
+}
+
+func writeRecord(w io.Writer, r *record) error {
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
@@ -0,0 +1,175 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := Open(dir)
+	require.NoError(t, err)
+	require.NoError(t, q.Enqueue(&types.WebhookPayload{GroupKey: "group1"}, url.Values{"owner": {"foo"}}))
+	require.NoError(t, q.Enqueue(&types.WebhookPayload{GroupKey: "group2"}, nil))
+	require.NoError(t, q.Close())
+
+	q, err = Open(dir)
+	require.NoError(t, err)
+	defer func() { _ = q.Close() }()
+	assert.Equal(t, 2, q.Len())
+
+	item, _ := q.next(time.Now())
+	require.NotNil(t, item)
+	assert.Equal(t, "group1", item.Payload.GroupKey)
+	assert.Equal(t, "foo", item.Params.Get("owner"))
+
+	require.NoError(t, q.ack(item.ID))
+	require.NoError(t, q.Close())
+
+	q, err = Open(dir)
+	require.NoError(t, err)
+	defer func() { _ = q.Close() }()
+	assert.Equal(t, 1, q.Len())
+	require.NoError(t, q.Enqueue(&types.WebhookPayload{GroupKey: "group3"}, nil))
+
+	item, _ = q.next(time.Now())
+	require.NotNil(t, item)
+	assert.Equal(t, "group2", item.Payload.GroupKey)
+}
+
+func TestQueueKeepsGroupOrder(t *testing.T) {
+	q, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer func() { _ = q.Close() }()
+
+	require.NoError(t, q.Enqueue(&types.WebhookPayload{GroupKey: "group1", Status: types.AlertStatusFiring}, nil))
+	require.NoError(t, q.Enqueue(&types.WebhookPayload{GroupKey: "group1", Status: types.AlertStatusResolved}, nil))
+	require.NoError(t, q.Enqueue(&types.WebhookPayload{GroupKey: "group2"}, nil))
+
+	now := time.Now()
+	first, _ := q.next(now)
+	require.NotNil(t, first)
+	assert.Equal(t, types.AlertStatusFiring, first.Payload.Status)
+
+	second, _ := q.next(now)
+	require.NotNil(t, second)
+	assert.Equal(t, "group2", second.Payload.GroupKey)
+
+	third, wait := q.next(now)
+	assert.Nil(t, third)
+	assert.Equal(t, time.Duration(-1), wait)
+
+	_, ok := q.retry(first.ID)
+	assert.True(t, ok)
+	third, wait = q.next(time.Now())
+	assert.Nil(t, third)
+	assert.Greater(t, wait, time.Duration(0))
+	assert.LessOrEqual(t, wait, q.InitialBackoff)
+}
+
+func TestQueueRunRetries(t *testing.T) {
+	q, err := Open(t.TempDir())
+	require.NoError(t, err)
+	defer func() { _ = q.Close() }()
+	q.InitialBackoff = time.Millisecond
+
+	var mu sync.Mutex
+	attempts := 0
+	done := make(chan struct{})
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		q.Run(ctx, 2, func(ctx context.Context, item *Item) error {
+			mu.Lock()
+			defer mu.Unlock()
+			attempts++
+			if attempts < 3 {
+				return errors.New("temporary failure")
+			}
+			close(done)
+			return nil
+		})
+	}()
+	require.NoError(t, q.Enqueue(&types.WebhookPayload{GroupKey: "group1"}, nil))
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the item was not processed")
+	}
+	cancel()
+
+	assert.Eventually(t, func() bool { return q.Len() == 0 }, 5*time.Second, time.Millisecond)
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, 3, attempts)
+}
+
+func TestQueueBackoff(t *testing.T) {
+	q := &Queue{InitialBackoff: time.Second, MaxBackoff: 10 * time.Second}
+	assert.Equal(t, time.Second, q.backoff(1))
+	assert.Equal(t, 2*time.Second, q.backoff(2))
+	assert.Equal(t, 8*time.Second, q.backoff(4))
+	assert.Equal(t, 10*time.Second, q.backoff(5))
+	assert.Equal(t, 10*time.Second, q.backoff(100))
+}
+
+func TestQueueDropsFailingItems(t *testing.T) {
+	dir := t.TempDir()
+	q, err := Open(dir)
+	require.NoError(t, err)
+	defer func() { _ = q.Close() }()
+	q.InitialBackoff = time.Millisecond
+	q.MaxAttempts = 3
+	errPermanent := errors.New("permanent failure")
+	q.IsPermanent = func(err error) bool { return errors.Is(err, errPermanent) }
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	var processed []string
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.Run(ctx, 1, func(ctx context.Context, item *Item) error {
+		mu.Lock()
+		defer mu.Unlock()
+		receiver := item.Payload.Receiver
+		attempts[receiver]++
+		switch receiver {
+		case "permanent":
+			return errPermanent
+		case "temporary":
+			return errors.New("temporary failure")
+		}
+		processed = append(processed, receiver)
+		return nil
+	})
+
+	// The failing items at the head of the group do not hold back the later ones forever.
+	require.NoError(t, q.Enqueue(&types.WebhookPayload{GroupKey: "group1", Receiver: "permanent"}, nil))
+	require.NoError(t, q.Enqueue(&types.WebhookPayload{GroupKey: "group1", Receiver: "temporary"}, nil))
+	require.NoError(t, q.Enqueue(&types.WebhookPayload{GroupKey: "group1", Receiver: "resolved"}, nil))
+	assert.Eventually(t, func() bool { return q.Len() == 0 }, 5*time.Second, time.Millisecond)
+
+	mu.Lock()
+	assert.Equal(t, map[string]int{"permanent": 1, "temporary": 3, "resolved": 1}, attempts)
+	assert.Equal(t, []string{"resolved"}, processed)
+	mu.Unlock()
+
+	b, err := os.ReadFile(filepath.Join(dir, deadLetterFileName))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	require.Len(t, lines, 2)
+	assert.Contains(t, lines[0], `"op":"permanent"`)
+	assert.Contains(t, lines[1], `"op":"max_attempts"`)
+}
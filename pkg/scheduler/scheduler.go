@@ -0,0 +1,301 @@
+package scheduler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+)
+
+const fileName = "tasks.json"
+
+var pendingTasksDesc = prometheus.NewDesc(
+	"scheduled_tasks_pending",
+	"Number of scheduled tasks waiting to be run.",
+	[]string{"kind"}, nil,
+)
+
+// Task is a notification to be made again at a later time.
+type Task struct {
+	// A task replaces the pending task with the same key.
+	Key  string    `json:"key"`
+	Kind string    `json:"kind"`
+	At   time.Time `json:"at"`
+
+	Owner   string                `json:"owner"`
+	Repo    string                `json:"repo"`
+	Note    string                `json:"note,omitempty"`
+	Payload *types.WebhookPayload `json:"payload"`
+	Params  url.Values            `json:"params,omitempty"`
+	// AlertID is the ID of the issue. It is empty for the tasks stored by older versions.
+	AlertID string `json:"alertID,omitempty"`
+	// PerAlert is true if the issue is for the only alert of Payload rather than the whole group.
+	PerAlert bool `json:"perAlert,omitempty"`
+	// RouteLabels are the common labels of the whole group, which the route is resolved from.
+	// They differ from the common labels of Payload if the group was split.
+	RouteLabels map[string]string `json:"routeLabels,omitempty"`
+}
+
+type Handler func(context.Context, *Task) error
+
+// Scheduler runs tasks when they are due. If it is backed by a directory, pending tasks survive restarts.
+type Scheduler struct {
+	// How long a failed task waits before it is retried.
+	RetryDelay time.Duration
+
+	mu    sync.Mutex
+	dir   string
+	tasks map[string]*Task
+	wake  chan struct{}
+}
+
+// NewMemory returns a scheduler whose tasks are lost on restart.
+func NewMemory() *Scheduler {
+	return &Scheduler{
+		RetryDelay: time.Minute,
+		tasks:      map[string]*Task{},
+		wake:       make(chan struct{}, 1),
+	}
+}
+
+// Open loads the tasks stored in dir, creating the directory if necessary.
+func Open(dir string) (*Scheduler, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	s := NewMemory()
+	s.dir = dir
+
+	b, err := os.ReadFile(s.path())
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		var tasks []*Task
+		if err := json.Unmarshal(b, &tasks); err != nil {
+			return nil, err
+		}
+		for _, task := range tasks {
+			s.tasks[task.Key] = task
+		}
+	}
+
+	if len(s.tasks) > 0 {
+		log.Info().Int("tasks", len(s.tasks)).Msg("restored scheduled tasks")
+	}
+	return s, nil
+}
+
+func (s *Scheduler) path() string {
+	return filepath.Join(s.dir, fileName)
+}
+
+// save writes all the tasks to the file. It must be called with s.mu held.
+func (s *Scheduler) save() error {
+	if s.dir == "" {
+		return nil
+	}
+
+	tasks := make([]*Task, 0, len(s.tasks))
+	for _, task := range s.tasks {
+		tasks = append(tasks, task)
+	}
+	b, err := json.Marshal(tasks)
+	if err != nil {
+		return err
+	}
+
+	tmpPath := s.path() + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmpPath, s.path()); err != nil {
+		return err
+	}
+	return syncDir(s.dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		_ = d.Close()
+		return err
+	}
+	return d.Close()
+}
+
+// Schedule adds the task, replacing the pending task with the same key.
+func (s *Scheduler) Schedule(task *Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous, ok := s.tasks[task.Key]
+	s.tasks[task.Key] = task
+	if err := s.save(); err != nil {
+		if ok {
+			s.tasks[task.Key] = previous
+		} else {
+			delete(s.tasks, task.Key)
+		}
+		return err
+	}
+
+	s.notify()
+	return nil
+}
+
+// Get returns the pending task with the key.
+func (s *Scheduler) Get(key string) (*Task, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[key]
+	return task, ok
+}
+
+// Cancel removes the pending task with the key, and returns whether there was one.
+func (s *Scheduler) Cancel(key string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	task, ok := s.tasks[key]
+	if !ok {
+		return false, nil
+	}
+	delete(s.tasks, key)
+	if err := s.save(); err != nil {
+		s.tasks[key] = task
+		return false, err
+	}
+	return true, nil
+}
+
+// done removes the task unless it has been replaced while it was running.
+func (s *Scheduler) done(task *Task) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tasks[task.Key] != task {
+		return nil
+	}
+	delete(s.tasks, task.Key)
+	return s.save()
+}
+
+func (s *Scheduler) retry(task *Task) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.tasks[task.Key] != task {
+		return
+	}
+	// The new time is not persisted. After a restart, the task is retried at once.
+	retried := *task
+	retried.At = time.Now().Add(s.RetryDelay)
+	s.tasks[task.Key] = &retried
+}
+
+// next returns a task which is due, and otherwise how long to wait until one becomes due.
+func (s *Scheduler) next(now time.Time) (*Task, time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	wait := time.Duration(-1)
+	for _, task := range s.tasks {
+		d := task.At.Sub(now)
+		if d <= 0 {
+			return task, 0
+		}
+		if wait < 0 || d < wait {
+			wait = d
+		}
+	}
+	return nil, wait
+}
+
+func (s *Scheduler) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// Run runs the tasks when they are due until ctx is canceled. Failed tasks are retried after RetryDelay.
+// It returns once the task in progress, if any, has finished.
+func (s *Scheduler) Run(ctx context.Context, handler Handler) {
+	for ctx.Err() == nil {
+		task, wait := s.next(time.Now())
+		if task != nil {
+			if err := handler(ctx, task); err != nil {
+				log.Error().Err(err).Str("key", task.Key).Str("kind", task.Kind).Msg("failed to run scheduled task")
+				s.retry(task)
+			} else if err := s.done(task); err != nil {
+				log.Error().Err(err).Str("key", task.Key).Msg("failed to remove scheduled task")
+			}
+			continue
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait >= 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-s.wake:
+		case <-timeout:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (s *Scheduler) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pendingTasksDesc
+}
+
+func (s *Scheduler) Collect(ch chan<- prometheus.Metric) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	counts := map[string]int{}
+	for _, task := range s.tasks {
+		counts[task.Kind]++
+	}
+	for kind, count := range counts {
+		ch <- prometheus.MustNewConstMetric(pendingTasksDesc, prometheus.GaugeValue, float64(count), kind)
+	}
+}
+
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.tasks)
+}
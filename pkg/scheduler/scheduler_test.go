@@ -0,0 +1,81 @@
+package scheduler
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSchedulerSurvivesReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	s, err := Open(dir)
+	require.NoError(t, err)
+	at := time.Now().Add(time.Hour).Round(0)
+	require.NoError(t, s.Schedule(&Task{Key: "a", Kind: "close", At: at, Payload: &types.WebhookPayload{GroupKey: "group1"}}))
+	require.NoError(t, s.Schedule(&Task{Key: "b", Kind: "close", At: at}))
+	// The task with the same key is replaced.
+	require.NoError(t, s.Schedule(&Task{Key: "a", Kind: "close", At: at, Owner: "foo", Payload: &types.WebhookPayload{GroupKey: "group1"}}))
+	canceled, err := s.Cancel("b")
+	require.NoError(t, err)
+	assert.True(t, canceled)
+
+	s, err = Open(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 1, s.Len())
+	task, ok := s.Get("a")
+	require.True(t, ok)
+	assert.Equal(t, "foo", task.Owner)
+	assert.Equal(t, "group1", task.Payload.GroupKey)
+	assert.True(t, at.Equal(task.At))
+
+	canceled, err = s.Cancel("b")
+	require.NoError(t, err)
+	assert.False(t, canceled)
+}
+
+func TestSchedulerRun(t *testing.T) {
+	s := NewMemory()
+	s.RetryDelay = 10 * time.Millisecond
+
+	var mu sync.Mutex
+	var ran []string
+	attempts := 0
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		s.Run(ctx, func(ctx context.Context, task *Task) error {
+			mu.Lock()
+			defer mu.Unlock()
+			if task.Key == "retried" {
+				attempts++
+				if attempts == 1 {
+					return errors.New("failed")
+				}
+			}
+			ran = append(ran, task.Key)
+			return nil
+		})
+	}()
+
+	now := time.Now()
+	require.NoError(t, s.Schedule(&Task{Key: "later", At: now.Add(50 * time.Millisecond)}))
+	require.NoError(t, s.Schedule(&Task{Key: "retried", At: now}))
+	require.NoError(t, s.Schedule(&Task{Key: "canceled", At: now.Add(20 * time.Millisecond)}))
+	_, err := s.Cancel("canceled")
+	require.NoError(t, err)
+
+	assert.Eventually(t, func() bool { return s.Len() == 0 }, time.Second, 10*time.Millisecond)
+	cancel()
+	<-done
+
+	assert.Equal(t, []string{"retried", "later"}, ran)
+	assert.Equal(t, 2, attempts)
+}
@@ -0,0 +1,122 @@
+package server
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Credentials which webhook requests may authenticate with.
+// Any one of them is accepted, so that they can be rotated.
+type Credentials struct {
+	BearerTokens []string
+	BasicAuth    []BasicAuthCredential
+}
+
+type BasicAuthCredential struct {
+	Username string
+	Password string
+}
+
+func (c *Credentials) empty() bool {
+	return c == nil || (len(c.BearerTokens) == 0 && len(c.BasicAuth) == 0)
+}
+
+func (c *Credentials) authenticate(r *http.Request) bool {
+	if username, password, ok := r.BasicAuth(); ok {
+		for _, cred := range c.BasicAuth {
+			// Compare both to take the same time regardless of which one mismatches.
+			u := subtle.ConstantTimeCompare([]byte(username), []byte(cred.Username))
+			p := subtle.ConstantTimeCompare([]byte(password), []byte(cred.Password))
+			if u&p == 1 {
+				return true
+			}
+		}
+		return false
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok {
+		return false
+	}
+	for _, t := range c.BearerTokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(t)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *Server) authMiddleware(c *gin.Context) {
+	if s.Credentials.empty() {
+		return
+	}
+
+	if !s.Credentials.authenticate(c.Request) {
+		log.Warn().Str("remoteAddr", c.ClientIP()).Str("path", c.Request.URL.Path).Msg("unauthorized request")
+		if len(s.Credentials.BasicAuth) > 0 {
+			c.Header("WWW-Authenticate", `Basic realm="alertmanager-to-github"`)
+		}
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "unauthorized"})
+		return
+	}
+}
+
+// ReadBearerTokenFile reads one token per line. Empty lines and lines starting with '#' are ignored.
+func ReadBearerTokenFile(path string) ([]string, error) {
+	return readCredentialLines(path)
+}
+
+// ReadBasicAuthFile reads one "username:password" pair per line.
+// Empty lines and lines starting with '#' are ignored.
+func ReadBasicAuthFile(path string) ([]BasicAuthCredential, error) {
+	lines, err := readCredentialLines(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var creds []BasicAuthCredential
+	for i, line := range lines {
+		username, password, ok := strings.Cut(line, ":")
+		if !ok || username == "" {
+			return nil, fmt.Errorf("%s: entry %d must be in the form of username:password", path, i+1)
+		}
+		creds = append(creds, BasicAuthCredential{Username: username, Password: password})
+	}
+	return creds, nil
+}
+
+func readCredentialLines(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if err := f.Close(); err != nil {
+			log.Error().Err(err).Msg("failed to close credential file")
+		}
+	}()
+
+	var lines []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		lines = append(lines, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("%s: no credentials found", path)
+	}
+	return lines, nil
+}
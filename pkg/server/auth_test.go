@@ -0,0 +1,81 @@
+package server
+
+import (
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestV1WebhookAuth(t *testing.T) {
+	creds := &Credentials{
+		BearerTokens: []string{"token1", "token2"},
+		BasicAuth:    []BasicAuthCredential{{Username: "user", Password: "pass"}},
+	}
+
+	tests := []struct {
+		name     string
+		username string
+		password string
+		header   string
+		expected int
+	}{
+		{name: "no credentials", expected: 401},
+		{name: "valid bearer token", header: "Bearer token2", expected: 200},
+		{name: "invalid bearer token", header: "Bearer token3", expected: 401},
+		{name: "valid basic auth", username: "user", password: "pass", expected: 200},
+		{name: "invalid basic auth", username: "user", password: "wrong", expected: 401},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			nt := &dummyNotifier{}
+			s := New(nt)
+			s.Credentials = creds
+			router := s.Router()
+
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/v1/webhook", strings.NewReader(`{"status": "firing"}`))
+			if tt.header != "" {
+				req.Header.Set("Authorization", tt.header)
+			}
+			if tt.username != "" {
+				req.SetBasicAuth(tt.username, tt.password)
+			}
+			router.ServeHTTP(w, req)
+
+			assert.Equal(t, tt.expected, w.Code)
+			if tt.expected == 401 {
+				assert.Empty(t, nt.payloads)
+			} else {
+				assert.Len(t, nt.payloads, 1)
+			}
+		})
+	}
+}
+
+func TestReadCredentialFiles(t *testing.T) {
+	dir := t.TempDir()
+
+	tokenFile := filepath.Join(dir, "tokens")
+	assert.NoError(t, os.WriteFile(tokenFile, []byte("# current\ntoken1\n\ntoken2\n"), 0o600))
+	tokens, err := ReadBearerTokenFile(tokenFile)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"token1", "token2"}, tokens)
+
+	basicAuthFile := filepath.Join(dir, "basic-auth")
+	assert.NoError(t, os.WriteFile(basicAuthFile, []byte("user1:pass:word\nuser2:pass2\n"), 0o600))
+	creds, err := ReadBasicAuthFile(basicAuthFile)
+	assert.NoError(t, err)
+	assert.Equal(t, []BasicAuthCredential{
+		{Username: "user1", Password: "pass:word"},
+		{Username: "user2", Password: "pass2"},
+	}, creds)
+
+	assert.NoError(t, os.WriteFile(basicAuthFile, []byte("invalid\n"), 0o600))
+	_, err = ReadBasicAuthFile(basicAuthFile)
+	assert.Error(t, err)
+}
@@ -0,0 +1,58 @@
+package server
+
+import (
+	"github.com/pfnet-research/alertmanager-to-github/pkg/matcher"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+var alertsDroppedCount = promauto.NewCounterVec(
+	prometheus.CounterOpts{
+		Name: "alerts_dropped_total",
+		Help: "Number of alerts dropped by the alert filter before being notified.",
+	},
+	// reason: "excluded" or "not_included"
+	[]string{"reason"},
+)
+
+// AlertFilter drops alerts from webhook payloads before they are notified.
+type AlertFilter struct {
+	// If set, only the alerts matching all of Include are notified.
+	Include matcher.Matchers
+	// The alerts matching any of Exclude are dropped.
+	Exclude matcher.Matchers
+}
+
+// Filter returns the payload with only the alerts to notify.
+// The payload is returned as is if no alerts are dropped.
+func (f *AlertFilter) Filter(payload *types.WebhookPayload) *types.WebhookPayload {
+	if f == nil {
+		return payload
+	}
+
+	kept := []types.WebhookAlert{}
+	for _, alert := range payload.Alerts {
+		if reason := f.dropReason(alert.Labels); reason != "" {
+			alertsDroppedCount.WithLabelValues(reason).Inc()
+			continue
+		}
+		kept = append(kept, alert)
+	}
+	if len(kept) == len(payload.Alerts) {
+		return payload
+	}
+	return payload.SubPayload(kept)
+}
+
+func (f *AlertFilter) dropReason(labels map[string]string) string {
+	for _, m := range f.Exclude {
+		if m.Matches(labels[m.Name]) {
+			return "excluded"
+		}
+	}
+	if !f.Include.Matches(labels) {
+		return "not_included"
+	}
+	return ""
+}
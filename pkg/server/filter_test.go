@@ -0,0 +1,67 @@
+package server
+
+import (
+	"context"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/matcher"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestV1WebhookFilter(t *testing.T) {
+	include, err := matcher.ParseAll([]string{`severity=~"critical|warning"`})
+	require.NoError(t, err)
+	exclude, err := matcher.ParseAll([]string{`alertname="Watchdog"`, `team="test"`})
+	require.NoError(t, err)
+
+	var notified *types.WebhookPayload
+	s := New(notifierFunc(func(ctx context.Context, payload *types.WebhookPayload, params url.Values) error {
+		notified = payload
+		return nil
+	}))
+	s.Filter = &AlertFilter{Include: include, Exclude: exclude}
+	router := s.Router()
+
+	tests := []struct {
+		name     string
+		alerts   string
+		expected []string
+	}{
+		{
+			name:     "partially filtered",
+			alerts:   `{"status": "firing", "labels": {"alertname": "A", "severity": "critical"}}, {"status": "firing", "labels": {"alertname": "B", "severity": "info"}}, {"status": "firing", "labels": {"alertname": "Watchdog", "severity": "critical"}}`,
+			expected: []string{"A"},
+		},
+		{
+			name:     "fully filtered",
+			alerts:   `{"status": "firing", "labels": {"alertname": "Watchdog", "severity": "critical"}}, {"status": "firing", "labels": {"alertname": "C", "severity": "warning", "team": "test"}}`,
+			expected: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			notified = nil
+			w := httptest.NewRecorder()
+			req := httptest.NewRequest("POST", "/v1/webhook", strings.NewReader(`{"groupKey": "group1", "status": "firing", "alerts": [`+tt.alerts+`]}`))
+			router.ServeHTTP(w, req)
+			assert.Equal(t, 200, w.Code)
+
+			if tt.expected == nil {
+				assert.Nil(t, notified)
+				return
+			}
+			require.NotNil(t, notified)
+			var names []string
+			for _, alert := range notified.Alerts {
+				names = append(names, alert.Labels["alertname"])
+			}
+			assert.Equal(t, tt.expected, names)
+			assert.Equal(t, "A", notified.CommonLabels["alertname"])
+		})
+	}
+}
@@ -3,16 +3,48 @@ package server
 import (
 	"context"
 	"net/http"
+	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/google/go-github/v54/github"
 	"github.com/pfnet-research/alertmanager-to-github/pkg/notifier"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/queue"
 	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/watchdog"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/rs/zerolog/log"
 )
 
+// ReadinessChecker returns an error if the server cannot handle requests.
+type ReadinessChecker interface {
+	CheckReady(ctx context.Context) error
+}
+
+// IssueCommentHandler handles the issue comments delivered by GitHub webhooks.
+type IssueCommentHandler interface {
+	HandleIssueComment(ctx context.Context, event *github.IssueCommentEvent) error
+}
+
 type Server struct {
 	Notifier notifier.Notifier
+	// If set, payloads are persisted to Queue and notified asynchronously.
+	Queue *queue.Queue
+	// If set, webhook requests must authenticate with one of Credentials.
+	Credentials *Credentials
+	// If set, /readyz reports whether Readiness is ready.
+	Readiness ReadinessChecker
+	// Timeout of each synchronous notification. 0 means no timeout.
+	NotifyTimeout time.Duration
+	// If set, the plans recorded in dry-run mode are served on /admin/plans.
+	Plans *notifier.PlanRecorder
+	// If set, alerts are filtered before they are queued or notified.
+	Filter *AlertFilter
+	// If set, heartbeat alerts are passed to Watchdog instead of being notified.
+	Watchdog *watchdog.Watchdog
+	// If set, GitHub webhooks signed with GitHubWebhookSecret are accepted on /v1/github-webhook,
+	// and their issue comments are passed to IssueComments.
+	IssueComments       IssueCommentHandler
+	GitHubWebhookSecret []byte
 }
 
 func New(notifier notifier.Notifier) (*Server) {
@@ -24,7 +56,15 @@ func New(notifier notifier.Notifier) (*Server) {
 func (s *Server) Router() *gin.Engine {
 	router := gin.Default()
 	router.GET("/metrics", gin.WrapH(promhttp.Handler()))
-	router.POST("/v1/webhook", s.v1Webhook)
+	router.GET("/healthz", s.healthz)
+	router.GET("/readyz", s.readyz)
+	router.POST("/v1/webhook", s.authMiddleware, s.v1Webhook)
+	if s.IssueComments != nil {
+		router.POST("/v1/github-webhook", s.v1GitHubWebhook)
+	}
+	if s.Plans != nil {
+		router.GET("/admin/plans", s.authMiddleware, s.adminPlans)
+	}
 
 	return router
 }
@@ -38,7 +78,33 @@ func (s *Server) v1Webhook(c *gin.Context) {
 		return
 	}
 
-	ctx := context.TODO()
+	alerts := len(payload.Alerts)
+	payload = s.Filter.Filter(s.Watchdog.Observe(payload))
+	if alerts > 0 && len(payload.Alerts) == 0 {
+		log.Debug().Str("groupKey", payload.GroupKey).Msg("all alerts were filtered out")
+		c.JSON(http.StatusOK, gin.H{})
+		return
+	}
+
+	if s.Queue != nil {
+		if err := s.Queue.Enqueue(payload, c.Request.URL.Query()); err != nil {
+			log.Error().Err(err).Msg("error enqueuing")
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{})
+		return
+	}
+
+	// The notification is not canceled even if the client disconnects,
+	// so that the GitHub API calls for an alert are not interrupted halfway.
+	ctx := context.WithoutCancel(c.Request.Context())
+	if s.NotifyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.NotifyTimeout)
+		defer cancel()
+	}
 	if err := s.Notifier.Notify(ctx, payload, c.Request.URL.Query()); err != nil {
 		log.Error().Err(err).Msg("error notifying")
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
@@ -47,3 +113,57 @@ func (s *Server) v1Webhook(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{})
 }
+
+func (s *Server) v1GitHubWebhook(c *gin.Context) {
+	body, err := github.ValidatePayload(c.Request, s.GitHubWebhookSecret)
+	if err != nil {
+		log.Warn().Err(err).Msg("invalid GitHub webhook")
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "invalid signature"})
+		return
+	}
+
+	event, err := github.ParseWebHook(github.WebHookType(c.Request), body)
+	if err != nil {
+		log.Debug().Err(err).Str("event", github.WebHookType(c.Request)).Msg("ignoring GitHub webhook")
+		c.JSON(http.StatusOK, gin.H{})
+		return
+	}
+	comment, ok := event.(*github.IssueCommentEvent)
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{})
+		return
+	}
+
+	ctx := context.WithoutCancel(c.Request.Context())
+	if s.NotifyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.NotifyTimeout)
+		defer cancel()
+	}
+	if err := s.IssueComments.HandleIssueComment(ctx, comment); err != nil {
+		log.Error().Err(err).Msg("error handling issue comment")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{})
+}
+
+func (s *Server) healthz(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (s *Server) readyz(c *gin.Context) {
+	if s.Readiness != nil {
+		if err := s.Readiness.CheckReady(c.Request.Context()); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"status": "not ready", "error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+func (s *Server) adminPlans(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"plans": s.Plans.Plans()})
+}
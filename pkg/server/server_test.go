@@ -2,12 +2,19 @@ package server
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"net/http/httptest"
 	"net/url"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/google/go-github/v54/github"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/notifier"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/queue"
 	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
 	"github.com/stretchr/testify/assert"
 )
@@ -75,6 +82,76 @@ func TestV1Webhook(t *testing.T) {
 	}
 }
 
+func TestV1WebhookQueued(t *testing.T) {
+	q, err := queue.Open(t.TempDir())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer func() { _ = q.Close() }()
+
+	nt := &dummyNotifier{}
+	s := New(nt)
+	s.Queue = q
+	router := s.Router()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v1/webhook?owner=foo&repo=bar", strings.NewReader(`{"groupKey": "group1", "status": "firing"}`))
+	router.ServeHTTP(w, req)
+	if !assert.Equal(t, 202, w.Code) {
+		t.Log(w.Body.String())
+	}
+	assert.Empty(t, nt.payloads)
+	assert.Equal(t, 1, q.Len())
+}
+
+type notifierFunc func(ctx context.Context, payload *types.WebhookPayload, params url.Values) error
+
+func (f notifierFunc) Notify(ctx context.Context, payload *types.WebhookPayload, params url.Values) error {
+	return f(ctx, payload, params)
+}
+
+func TestV1WebhookNotifyTimeout(t *testing.T) {
+	called := false
+	s := New(notifierFunc(func(ctx context.Context, payload *types.WebhookPayload, params url.Values) error {
+		called = true
+		deadline, ok := ctx.Deadline()
+		assert.True(t, ok)
+		assert.WithinDuration(t, time.Now().Add(time.Minute), deadline, 10*time.Second)
+		// The notification is not canceled even if the client has disconnected.
+		assert.NoError(t, ctx.Err())
+		return nil
+	}))
+	s.NotifyTimeout = time.Minute
+	router := s.Router()
+
+	reqCtx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("POST", "/v1/webhook", strings.NewReader(`{"groupKey": "group1", "status": "firing"}`)).WithContext(reqCtx)
+	router.ServeHTTP(w, req)
+
+	assert.Equal(t, 200, w.Code)
+	assert.True(t, called)
+}
+
+func TestAdminPlans(t *testing.T) {
+	s := New(&dummyNotifier{})
+	s.Plans = notifier.NewPlanRecorder(10)
+	s.Plans.Record(&notifier.Plan{AlertID: "alert1"})
+	s.Credentials = &Credentials{BearerTokens: []string{"token"}}
+	router := s.Router()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/admin/plans", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 401, w.Code)
+
+	w = httptest.NewRecorder()
+	req.Header.Set("Authorization", "Bearer token")
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+	assert.Contains(t, w.Body.String(), `"alertID":"alert1"`)
+}
+
 func TestMetrics(t *testing.T) {
 	nt := &dummyNotifier{}
 	router := New(nt).Router()
@@ -86,6 +163,40 @@ func TestMetrics(t *testing.T) {
 	}
 }
 
+type readinessFunc func(ctx context.Context) error
+
+func (f readinessFunc) CheckReady(ctx context.Context) error {
+	return f(ctx)
+}
+
+func TestHealthz(t *testing.T) {
+	s := New(&dummyNotifier{})
+	s.Readiness = readinessFunc(func(ctx context.Context) error { return errors.New("not ready") })
+	router := s.Router()
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/healthz", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+}
+
+func TestReadyz(t *testing.T) {
+	var err error
+	s := New(&dummyNotifier{})
+	s.Readiness = readinessFunc(func(ctx context.Context) error { return err })
+	router := s.Router()
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/readyz", nil)
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 200, w.Code)
+
+	err = errors.New("rate limit exhausted")
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assert.Equal(t, 503, w.Code)
+	assert.Contains(t, w.Body.String(), "rate limit exhausted")
+}
+
 func mustParseTime(s string) time.Time {
 	t, err := time.Parse(time.RFC3339, s)
 	if err != nil {
@@ -93,3 +204,38 @@ func mustParseTime(s string) time.Time {
 	}
 	return t
 }
+
+type issueCommentHandlerFunc func(ctx context.Context, event *github.IssueCommentEvent) error
+
+func (f issueCommentHandlerFunc) HandleIssueComment(ctx context.Context, event *github.IssueCommentEvent) error {
+	return f(ctx, event)
+}
+
+func TestV1GitHubWebhook(t *testing.T) {
+	var comments []string
+	s := New(&dummyNotifier{})
+	s.GitHubWebhookSecret = []byte("secret")
+	s.IssueComments = issueCommentHandlerFunc(func(ctx context.Context, event *github.IssueCommentEvent) error {
+		comments = append(comments, event.GetComment().GetBody())
+		return nil
+	})
+	router := s.Router()
+
+	send := func(event string, body string, secret string) int {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(body))
+		req := httptest.NewRequest("POST", "/v1/github-webhook", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("X-GitHub-Event", event)
+		req.Header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	comment := `{"action": "created", "comment": {"body": "/silence 1h test"}}`
+	assert.Equal(t, 401, send("issue_comment", comment, "wrong"))
+	assert.Equal(t, 200, send("ping", `{"zen": "hello"}`, "secret"))
+	assert.Equal(t, 200, send("issue_comment", comment, "secret"))
+	assert.Equal(t, []string{"/silence 1h test"}, comments)
+}
@@ -14,10 +14,15 @@ import (
 type Vars struct {
 	Payload       *types.WebhookPayload
 	PreviousIssue *github.Issue
+	// Alert is the alert the issue is for, or nil if the issue is for the whole group.
+	Alert *types.WebhookAlert
+	// Changes is set only when rendering a comment.
+	Changes *types.AlertChanges
 }
 
 type Template struct {
-	inner *template.Template
+	inner  *template.Template
+	source string
 }
 
 func Parse(s string) (*Template, error) {
@@ -30,15 +35,22 @@ func Parse(s string) (*Template, error) {
 	if err != nil {
 		return nil, err
 	}
-	return &Template{inner: t}, nil
+	return &Template{inner: t, source: s}, nil
+}
+
+// Source returns the text the template was parsed from.
+func (t *Template) Source() string {
+	return t.source
 }
 
 func (t *Template) Execute(payload *types.WebhookPayload, previousIssue *github.Issue) (string, error) {
-	vars := &Vars{
+	return t.ExecuteVars(&Vars{
 		Payload:       payload,
 		PreviousIssue: previousIssue,
-	}
+	})
+}
 
+func (t *Template) ExecuteVars(vars *Vars) (string, error) {
 	var buf bytes.Buffer
 	if err := t.inner.Execute(&buf, vars); err != nil {
 		return "", err
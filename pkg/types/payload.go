@@ -1,6 +1,8 @@
 package types
 
 import (
+	"crypto/sha256"
+	"fmt"
 	"sort"
 	"time"
 )
@@ -11,8 +13,10 @@ const (
 	AlertStatusResolved AlertStatus = "resolved"
 	AlertStatusFiring   AlertStatus = "firing"
 
-	skipAutoCloseAnnotationKey   = "atg_skip_auto_close"
-	skipAutoCloseAnnotationValue = "true"
+	skipAutoCloseAnnotationKey     = "atg_skip_auto_close"
+	skipAutoCloseAnnotationValue   = "true"
+	autoCloseDelayAnnotationKey    = "atg_auto_close_delay"
+	minFiringDurationAnnotationKey = "atg_min_firing_duration"
 )
 
 type WebhookPayload struct {
@@ -95,3 +99,121 @@ func (p *WebhookPayload) HasSkipAutoCloseAnnotation() bool {
 
 	return false
 }
+
+// AutoCloseDelay returns the longest `atg_auto_close_delay` annotation of the alerts.
+func (p *WebhookPayload) AutoCloseDelay() (time.Duration, bool, error) {
+	return p.durationAnnotation(autoCloseDelayAnnotationKey)
+}
+
+// MinFiringDuration returns the longest `atg_min_firing_duration` annotation of the alerts.
+func (p *WebhookPayload) MinFiringDuration() (time.Duration, bool, error) {
+	return p.durationAnnotation(minFiringDurationAnnotationKey)
+}
+
+// FiringDuration returns how long the longest firing alert has fired, or had fired until it was resolved.
+func (p *WebhookPayload) FiringDuration(now time.Time) time.Duration {
+	var longest time.Duration
+	for _, alert := range p.Alerts {
+		end := now
+		if alert.Status == AlertStatusResolved {
+			end = alert.EndsAt
+		}
+		if d := end.Sub(alert.StartsAt); d > longest {
+			longest = d
+		}
+	}
+	return longest
+}
+
+func (p *WebhookPayload) durationAnnotation(key string) (time.Duration, bool, error) {
+	var longest time.Duration
+	found := false
+	for _, alert := range p.Alerts {
+		val, ok := alert.Annotations[key]
+		if !ok {
+			continue
+		}
+		d, err := time.ParseDuration(val)
+		if err != nil {
+			return 0, false, fmt.Errorf("invalid %s annotation: %w", key, err)
+		}
+		if !found || d > longest {
+			longest = d
+		}
+		found = true
+	}
+	return longest, found, nil
+}
+
+// SubPayload returns a payload containing only the given alerts.
+// The common labels and annotations, and the status are recomputed from them.
+func (p *WebhookPayload) SubPayload(alerts []WebhookAlert) *WebhookPayload {
+	sub := *p
+	sub.TruncatedAlerts = 0
+	sub.Alerts = alerts
+	sub.CommonLabels = commonMap(alerts, func(a WebhookAlert) map[string]string { return a.Labels })
+	sub.CommonAnnotations = commonMap(alerts, func(a WebhookAlert) map[string]string { return a.Annotations })
+
+	sub.Status = AlertStatusResolved
+	for _, alert := range alerts {
+		if alert.Status == AlertStatusFiring {
+			sub.Status = AlertStatusFiring
+			break
+		}
+	}
+	return &sub
+}
+
+func commonMap(alerts []WebhookAlert, get func(WebhookAlert) map[string]string) map[string]string {
+	common := map[string]string{}
+	if len(alerts) == 0 {
+		return common
+	}
+	for k, v := range get(alerts[0]) {
+		common[k] = v
+	}
+	for _, alert := range alerts[1:] {
+		m := get(alert)
+		for k, v := range common {
+			if m[k] != v {
+				delete(common, k)
+			}
+		}
+	}
+	return common
+}
+
+// Fingerprint returns a stable hash of the labels of the alert.
+func (a *WebhookAlert) Fingerprint() string {
+	keys := make([]string, 0, len(a.Labels))
+	for k := range a.Labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	h := sha256.New()
+	for _, k := range keys {
+		// Separate with a byte which never appears in valid UTF-8.
+		h.Write([]byte(k))
+		h.Write([]byte{0xff})
+		h.Write([]byte(a.Labels[k]))
+		h.Write([]byte{0xff})
+	}
+	return fmt.Sprintf("%x", h.Sum(nil))
+}
+
+// AlertChanges describes how the alerts of a group have changed since the last notification.
+type AlertChanges struct {
+	// Alerts which have started firing.
+	Added []WebhookAlert
+	// Alerts which were firing and have been resolved.
+	Resolved []WebhookAlert
+	// The group has been resolved.
+	GroupResolved bool
+	// The group has started firing again after it was resolved.
+	GroupRefired bool
+}
+
+func (c *AlertChanges) Empty() bool {
+	return len(c.Added) == 0 && len(c.Resolved) == 0 && !c.GroupResolved && !c.GroupRefired
+}
@@ -2,8 +2,10 @@ package types
 
 import (
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestWebhookPayloadHasSkipAutoCloseAnnotation(t *testing.T) {
@@ -90,3 +92,73 @@ func TestWebhookPayloadHasSkipAutoCloseAnnotation(t *testing.T) {
 		})
 	}
 }
+
+func TestWebhookPayloadSubPayload(t *testing.T) {
+	payload := &WebhookPayload{
+		GroupKey:          "group1",
+		TruncatedAlerts:   1,
+		Status:            AlertStatusFiring,
+		GroupLabels:       map[string]string{"alertname": "Test"},
+		CommonLabels:      map[string]string{"alertname": "Test"},
+		CommonAnnotations: map[string]string{},
+		Alerts: []WebhookAlert{
+			{
+				Status:      AlertStatusFiring,
+				Labels:      map[string]string{"alertname": "Test", "instance": "a", "job": "x"},
+				Annotations: map[string]string{"summary": "a is down"},
+			},
+			{
+				Status:      AlertStatusResolved,
+				Labels:      map[string]string{"alertname": "Test", "instance": "b", "job": "x"},
+				Annotations: map[string]string{"summary": "b is down"},
+			},
+		},
+	}
+
+	sub := payload.SubPayload(payload.Alerts[1:])
+	assert.Equal(t, "group1", sub.GroupKey)
+	assert.Equal(t, uint64(0), sub.TruncatedAlerts)
+	assert.Equal(t, AlertStatusResolved, sub.Status)
+	assert.Equal(t, map[string]string{"alertname": "Test", "instance": "b", "job": "x"}, sub.CommonLabels)
+	assert.Equal(t, map[string]string{"summary": "b is down"}, sub.CommonAnnotations)
+	assert.Len(t, sub.Alerts, 1)
+
+	sub = payload.SubPayload(payload.Alerts)
+	assert.Equal(t, AlertStatusFiring, sub.Status)
+	assert.Equal(t, map[string]string{"alertname": "Test", "job": "x"}, sub.CommonLabels)
+	assert.Equal(t, map[string]string{}, sub.CommonAnnotations)
+	// The original payload is not modified.
+	assert.Equal(t, map[string]string{"alertname": "Test"}, payload.CommonLabels)
+}
+
+func TestWebhookAlertFingerprint(t *testing.T) {
+	a := &WebhookAlert{Labels: map[string]string{"alertname": "Test", "instance": "a"}}
+	b := &WebhookAlert{Labels: map[string]string{"instance": "a", "alertname": "Test"}, Status: AlertStatusResolved}
+	c := &WebhookAlert{Labels: map[string]string{"alertname": "Test", "instance": "b"}}
+	d := &WebhookAlert{Labels: map[string]string{"alertname": "Testinstance", "": "a"}}
+
+	assert.Equal(t, a.Fingerprint(), b.Fingerprint())
+	assert.NotEqual(t, a.Fingerprint(), c.Fingerprint())
+	assert.NotEqual(t, a.Fingerprint(), d.Fingerprint())
+	assert.Regexp(t, `^[0-9a-f]{64}$`, a.Fingerprint())
+}
+
+func TestFiringDuration(t *testing.T) {
+	now := time.Now()
+	payload := &WebhookPayload{Alerts: []WebhookAlert{
+		{Status: AlertStatusResolved, StartsAt: now.Add(-time.Hour), EndsAt: now.Add(-30 * time.Minute)},
+		{Status: AlertStatusFiring, StartsAt: now.Add(-10 * time.Minute)},
+	}}
+	assert.Equal(t, 30*time.Minute, payload.FiringDuration(now))
+
+	payload.Alerts[0].Annotations = map[string]string{"atg_min_firing_duration": "5m"}
+	payload.Alerts[1].Annotations = map[string]string{"atg_min_firing_duration": "15m"}
+	d, ok, err := payload.MinFiringDuration()
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 15*time.Minute, d)
+
+	payload.Alerts[1].Annotations["atg_min_firing_duration"] = "soon"
+	_, _, err = payload.MinFiringDuration()
+	assert.Error(t, err)
+}
@@ -0,0 +1,232 @@
+package watchdog
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/matcher"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/notifier"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	groupKey  = "alertmanager-to-github/watchdog"
+	alertName = "AlertmanagerHeartbeatMissing"
+	fileName  = "watchdog.json"
+)
+
+var lastHeartbeat = promauto.NewGauge(
+	prometheus.GaugeOpts{
+		Name: "watchdog_last_heartbeat_timestamp_seconds",
+		Help: "Timestamp of the last heartbeat alert received.",
+	},
+)
+
+// Watchdog opens an issue if heartbeat alerts, such as the always-firing Watchdog alert, stop arriving,
+// and closes it once they resume.
+type Watchdog struct {
+	// Matchers identify the heartbeat alerts.
+	Matchers matcher.Matchers
+	// The issue is opened if no heartbeat has arrived for Timeout.
+	Timeout  time.Duration
+	Notifier notifier.Notifier
+	// Params are passed to the notifier like the webhook URL parameters.
+	Params url.Values
+	// If set, whether heartbeats are missing is persisted in Dir, so that the issue is closed after a restart.
+	Dir string
+	// Bounds the notifications of the watchdog issue. 0 means no timeout.
+	NotifyTimeout time.Duration
+
+	mu       sync.Mutex
+	lastSeen time.Time
+	// missingSince is when heartbeats went missing, or zero while they arrive.
+	missingSince time.Time
+}
+
+// state is the part of the watchdog persisted across restarts.
+type state struct {
+	LastSeen     time.Time `json:"lastSeen"`
+	MissingSince time.Time `json:"missingSince"`
+}
+
+// Start starts the timeout from now rather than from the last heartbeat, which is unknown.
+// If heartbeats were missing before a restart, the state is restored instead, so that the open issue is closed once they resume.
+func (w *Watchdog) Start() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.lastSeen = time.Now()
+	if w.Dir == "" {
+		return nil
+	}
+	if err := os.MkdirAll(w.Dir, 0o700); err != nil {
+		return err
+	}
+	b, err := os.ReadFile(w.path())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var st state
+	if err := json.Unmarshal(b, &st); err != nil {
+		return err
+	}
+	if !st.MissingSince.IsZero() {
+		log.Info().Time("missingSince", st.MissingSince).Msg("restored missing heartbeats")
+		w.lastSeen, w.missingSince = st.LastSeen, st.MissingSince
+	}
+	return nil
+}
+
+func (w *Watchdog) path() string {
+	return filepath.Join(w.Dir, fileName)
+}
+
+// save persists the state. It must be called with w.mu held.
+func (w *Watchdog) save() error {
+	if w.Dir == "" {
+		return nil
+	}
+	b, err := json.Marshal(&state{LastSeen: w.lastSeen, MissingSince: w.missingSince})
+	if err != nil {
+		return err
+	}
+	tmpPath := w.path() + ".tmp"
+	if err := os.WriteFile(tmpPath, b, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, w.path())
+}
+
+// Observe records the heartbeats in the payload, and returns the payload without them.
+// The payload is returned as is if it has no heartbeats.
+func (w *Watchdog) Observe(payload *types.WebhookPayload) *types.WebhookPayload {
+	if w == nil {
+		return payload
+	}
+
+	others := []types.WebhookAlert{}
+	heartbeat := false
+	for _, alert := range payload.Alerts {
+		if !w.Matchers.Matches(alert.Labels) {
+			others = append(others, alert)
+			continue
+		}
+		if alert.Status == types.AlertStatusFiring {
+			heartbeat = true
+		}
+	}
+	if len(others) == len(payload.Alerts) {
+		return payload
+	}
+
+	if heartbeat {
+		w.mu.Lock()
+		w.lastSeen = time.Now()
+		w.mu.Unlock()
+		lastHeartbeat.SetToCurrentTime()
+	}
+	return payload.SubPayload(others)
+}
+
+// Run checks the heartbeats every interval until ctx is canceled.
+func (w *Watchdog) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := w.checkInBackground(ctx, now); err != nil {
+				log.Error().Err(err).Msg("failed to notify the watchdog status")
+			}
+		}
+	}
+}
+
+// checkInBackground checks the heartbeats without being canceled with ctx,
+// so that the watchdog issue is not left half updated on shutdown.
+func (w *Watchdog) checkInBackground(ctx context.Context, now time.Time) error {
+	ctx = context.WithoutCancel(ctx)
+	if w.NotifyTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, w.NotifyTimeout)
+		defer cancel()
+	}
+	return w.check(ctx, now)
+}
+
+func (w *Watchdog) check(ctx context.Context, now time.Time) error {
+	w.mu.Lock()
+	lastSeen, missingSince := w.lastSeen, w.missingSince
+	w.mu.Unlock()
+
+	deadline := lastSeen.Add(w.Timeout)
+	switch {
+	case missingSince.IsZero() && now.After(deadline):
+		log.Warn().Time("lastHeartbeat", lastSeen).Msg("heartbeat alerts are missing")
+		if err := w.Notifier.Notify(ctx, w.payload(types.AlertStatusFiring, deadline, lastSeen), w.Params); err != nil {
+			return err
+		}
+		missingSince = deadline
+	case !missingSince.IsZero() && !now.After(deadline):
+		log.Info().Time("lastHeartbeat", lastSeen).Msg("heartbeat alerts have resumed")
+		if err := w.Notifier.Notify(ctx, w.payload(types.AlertStatusResolved, missingSince, lastSeen), w.Params); err != nil {
+			return err
+		}
+		missingSince = time.Time{}
+	default:
+		return nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.missingSince = missingSince
+	return w.save()
+}
+
+// payload returns the alert about the missing heartbeats.
+func (w *Watchdog) payload(status types.AlertStatus, startsAt time.Time, lastSeen time.Time) *types.WebhookPayload {
+	labels := map[string]string{"alertname": alertName}
+	annotations := map[string]string{
+		"summary": "The alerting pipeline is broken",
+		"description": fmt.Sprintf("No heartbeat alert matching %s arrived within %s. Alerts may not be reaching GitHub.",
+			w.Matchers, w.Timeout),
+	}
+	if status == types.AlertStatusResolved {
+		annotations["description"] = fmt.Sprintf("Heartbeat alerts matching %s resumed at %s.",
+			w.Matchers, lastSeen.Format(time.RFC3339))
+	}
+	alert := types.WebhookAlert{
+		Status:      status,
+		Labels:      labels,
+		Annotations: annotations,
+		StartsAt:    startsAt,
+	}
+	if status == types.AlertStatusResolved {
+		alert.EndsAt = lastSeen
+	}
+	return &types.WebhookPayload{
+		Version:           "4",
+		GroupKey:          groupKey,
+		Status:            status,
+		Receiver:          "alertmanager-to-github",
+		GroupLabels:       labels,
+		CommonLabels:      labels,
+		CommonAnnotations: annotations,
+		Alerts:            []types.WebhookAlert{alert},
+	}
+}
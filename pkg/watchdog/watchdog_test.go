@@ -0,0 +1,115 @@
+package watchdog
+
+import (
+	"context"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/pfnet-research/alertmanager-to-github/pkg/matcher"
+	"github.com/pfnet-research/alertmanager-to-github/pkg/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type notifierFunc func(ctx context.Context, payload *types.WebhookPayload, params url.Values) error
+
+func (f notifierFunc) Notify(ctx context.Context, payload *types.WebhookPayload, params url.Values) error {
+	return f(ctx, payload, params)
+}
+
+func TestWatchdog(t *testing.T) {
+	matchers, err := matcher.ParseAll([]string{`alertname="Watchdog"`})
+	require.NoError(t, err)
+
+	var notified []*types.WebhookPayload
+	w := &Watchdog{
+		Matchers: matchers,
+		Timeout:  10 * time.Minute,
+		Params:   url.Values{"owner": {"foo"}, "repo": {"bar"}},
+		Notifier: notifierFunc(func(ctx context.Context, payload *types.WebhookPayload, params url.Values) error {
+			assert.Equal(t, "foo", params.Get("owner"))
+			notified = append(notified, payload)
+			return nil
+		}),
+	}
+	require.NoError(t, w.Start())
+	ctx := context.Background()
+	now := time.Now()
+
+	// Heartbeats are removed from the payload.
+	payload := &types.WebhookPayload{Alerts: []types.WebhookAlert{
+		{Status: types.AlertStatusFiring, Labels: map[string]string{"alertname": "Watchdog"}},
+		{Status: types.AlertStatusFiring, Labels: map[string]string{"alertname": "Other"}},
+	}}
+	observed := w.Observe(payload)
+	require.Len(t, observed.Alerts, 1)
+	assert.Equal(t, "Other", observed.Alerts[0].Labels["alertname"])
+	other := &types.WebhookPayload{Alerts: payload.Alerts[1:]}
+	assert.Same(t, other, w.Observe(other))
+
+	require.NoError(t, w.check(ctx, now.Add(5*time.Minute)))
+	assert.Empty(t, notified)
+
+	require.NoError(t, w.check(ctx, now.Add(11*time.Minute)))
+	require.Len(t, notified, 1)
+	assert.Equal(t, types.AlertStatusFiring, notified[0].Status)
+	// The issue is opened only once.
+	require.NoError(t, w.check(ctx, now.Add(12*time.Minute)))
+	require.Len(t, notified, 1)
+
+	w.Observe(payload)
+	require.NoError(t, w.check(ctx, time.Now()))
+	require.Len(t, notified, 2)
+	assert.Equal(t, types.AlertStatusResolved, notified[1].Status)
+	assert.Equal(t, notified[0].GroupKey, notified[1].GroupKey)
+	assert.Equal(t, notified[0].Alerts[0].StartsAt, notified[1].Alerts[0].StartsAt)
+}
+
+func TestWatchdogSurvivesRestart(t *testing.T) {
+	matchers, err := matcher.ParseAll([]string{`alertname="Watchdog"`})
+	require.NoError(t, err)
+
+	dir := t.TempDir()
+	var notified []*types.WebhookPayload
+	newWatchdog := func() *Watchdog {
+		w := &Watchdog{
+			Matchers: matchers,
+			Timeout:  10 * time.Minute,
+			Dir:      dir,
+			Notifier: notifierFunc(func(ctx context.Context, payload *types.WebhookPayload, params url.Values) error {
+				notified = append(notified, payload)
+				return nil
+			}),
+		}
+		require.NoError(t, w.Start())
+		return w
+	}
+	ctx := context.Background()
+	heartbeat := &types.WebhookPayload{Alerts: []types.WebhookAlert{
+		{Status: types.AlertStatusFiring, Labels: map[string]string{"alertname": "Watchdog"}},
+	}}
+
+	w := newWatchdog()
+	w.lastSeen = time.Now().Add(-11 * time.Minute)
+	require.NoError(t, w.check(ctx, time.Now()))
+	require.Len(t, notified, 1)
+	assert.Equal(t, types.AlertStatusFiring, notified[0].Status)
+
+	// The restarted watchdog neither opens the issue again nor closes it before heartbeats resume.
+	w = newWatchdog()
+	require.NoError(t, w.check(ctx, time.Now()))
+	require.NoError(t, w.check(ctx, time.Now().Add(11*time.Minute)))
+	require.Len(t, notified, 1)
+
+	w.Observe(heartbeat)
+	require.NoError(t, w.check(ctx, time.Now()))
+	require.Len(t, notified, 2)
+	assert.Equal(t, types.AlertStatusResolved, notified[1].Status)
+	assert.True(t, notified[0].Alerts[0].StartsAt.Equal(notified[1].Alerts[0].StartsAt))
+
+	// Once resolved, the restarted watchdog starts the timeout from now.
+	w = newWatchdog()
+	require.NoError(t, w.check(ctx, time.Now()))
+	require.Len(t, notified, 2)
+}